@@ -39,6 +39,11 @@ const (
 	// Onboard LED
 	LED Pin = GPIO25
 
+	// Pins wired to board functions, see Pin.IsReserved: GPIO23 selects the
+	// power save mode of the SMPS, GPIO24 senses VBUS and GPIO29 measures
+	// VSYS/3 through ADC3.
+	reservedPins = 1<<GPIO23 | 1<<GPIO24 | 1<<GPIO29
+
 	// Onboard crystal oscillator frequency, in MHz.
 	xoscFreq = 12 // MHz
 )
@@ -79,7 +84,9 @@ const (
 	UART_RX_PIN  = UART0_RX_PIN
 )
 
-// UART on the RP2040
+// UART on the RP2040. UART0 and UART1 are the only instances of the two UART
+// peripherals. UART0 is the default UART, on GPIO0 (TX) and GPIO1 (RX); UART1
+// is usually wired to GPIO8 (TX) and GPIO9 (RX).
 var (
 	UART0  = &_UART0
 	_UART0 = UART{
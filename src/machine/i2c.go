@@ -17,6 +17,16 @@ var _ interface { // 2
 	SetBaudRate(br uint32) error
 } = (*I2C)(nil)
 
+// BusReadWriter is a bus over which a write followed by a read is made to the
+// device at addr, as done by I2C Tx. Drivers which take a BusReadWriter work
+// with any bus implementing it, such as a hardware I2C peripheral or an
+// adapter for devices on other buses which ignores addr.
+type BusReadWriter interface {
+	Tx(addr uint16, w, r []byte) error
+}
+
+var _ BusReadWriter = (*I2C)(nil)
+
 // TWI_FREQ is the I2C bus speed. Normally either 100 kHz, or 400 kHz for high-speed bus.
 //
 // Deprecated: use 100 * machine.KHz or 400 * machine.KHz instead.
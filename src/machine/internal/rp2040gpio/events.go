@@ -0,0 +1,24 @@
+// Package rp2040gpio decodes the GPIO interrupt registers of the RP2040. It
+// holds the pure parts of the pin interrupt handler of package machine, so they
+// can be tested on the host.
+package rp2040gpio
+
+import "math/bits"
+
+// NextEvent returns the lowest pin with events set in status, the contents of
+// the INTS, INTR or INTE registers of IO_BANK0, along with its 4 event bits,
+// and clears them from status. Each register holds the events of 8 pins, 4
+// bits per pin starting from the lowest; only 30 pins exist, so the top 8
+// bits of the last register are unused. ok is false once no events are left.
+func NextEvent(status *[4]uint32) (pin uint8, events uint8, ok bool) {
+	for i := range status {
+		if status[i] == 0 {
+			continue
+		}
+		shift := bits.TrailingZeros32(status[i]) &^ 3
+		events = uint8(status[i]>>shift) & 0xf
+		status[i] &^= 0xf << shift
+		return uint8(i*8 + shift/4), events, true
+	}
+	return 0, 0, false
+}
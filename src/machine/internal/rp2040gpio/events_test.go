@@ -0,0 +1,69 @@
+package rp2040gpio
+
+import "testing"
+
+func TestNextEvent(t *testing.T) {
+	type event struct {
+		pin, events uint8
+	}
+	tests := []struct {
+		name   string
+		status [4]uint32
+		want   []event
+	}{
+		{name: "none"},
+		{
+			name:   "first pin of each register",
+			status: [4]uint32{0x4, 0x8, 0x1, 0x2},
+			want:   []event{{0, 0x4}, {8, 0x8}, {16, 0x1}, {24, 0x2}},
+		},
+		{
+			name:   "pins 0, 7, 8, 24 and 29",
+			status: [4]uint32{0xc000_0004, 0x0000_0008, 0, 0x00c0_0004},
+			want:   []event{{0, 0x4}, {7, 0xc}, {8, 0x8}, {24, 0x4}, {29, 0xc}},
+		},
+		{
+			name:   "all events of a pin",
+			status: [4]uint32{0, 0, 0x0f00_0000, 0},
+			want:   []event{{22, 0xf}},
+		},
+		{
+			name:   "every pin",
+			status: [4]uint32{0x4444_4444, 0x4444_4444, 0x4444_4444, 0x0044_4444},
+			want: func() []event {
+				var all []event
+				for p := uint8(0); p < 30; p++ {
+					all = append(all, event{p, 0x4})
+				}
+				return all
+			}(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := tt.status
+			var got []event
+			for {
+				pin, events, ok := NextEvent(&status)
+				if !ok {
+					break
+				}
+				got = append(got, event{pin, events})
+				if len(got) > 32 {
+					t.Fatal("too many events")
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("event %d: got %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+			if status != [4]uint32{} {
+				t.Errorf("status not cleared: %x", status)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+// Package rp2040pio encodes RP2040 PIO instructions. Package machine
+// re-exports it with a PIO prefix, see machine.PIOJmp and the following
+// functions for documentation; it is a separate package so the encodings can
+// be tested on the host. See section 3.4 of the RP2040 datasheet for the
+// instruction formats.
+package rp2040pio
+
+// JmpCond is the condition of a JMP instruction.
+type JmpCond uint8
+
+const (
+	JmpAlways      JmpCond = iota // (no condition)
+	JmpXZero                      // !x
+	JmpXNotZeroDec                // x--
+	JmpYZero                      // !y
+	JmpYNotZeroDec                // y--
+	JmpXNotEqualY                 // x!=y
+	JmpPin                        // pin
+	JmpOSRNotEmpty                // !osre
+)
+
+// WaitSrc is the source of a WAIT instruction.
+type WaitSrc uint8
+
+const (
+	WaitGPIO WaitSrc = iota // Absolute GPIO number.
+	WaitPin                 // Pin relative to the IN pin mapping.
+	WaitIRQ                 // PIO IRQ flag.
+)
+
+// InSrc is the source of an IN instruction.
+type InSrc uint8
+
+const (
+	InPins InSrc = 0
+	InX    InSrc = 1
+	InY    InSrc = 2
+	InNull InSrc = 3
+	InISR  InSrc = 6
+	InOSR  InSrc = 7
+)
+
+// OutDest is the destination of an OUT instruction.
+type OutDest uint8
+
+const (
+	OutPins    OutDest = 0
+	OutX       OutDest = 1
+	OutY       OutDest = 2
+	OutNull    OutDest = 3
+	OutPindirs OutDest = 4
+	OutPC      OutDest = 5
+	OutISR     OutDest = 6
+	OutExec    OutDest = 7
+)
+
+// MovDest is the destination of a MOV instruction.
+type MovDest uint8
+
+const (
+	MovDestPins MovDest = 0
+	MovDestX    MovDest = 1
+	MovDestY    MovDest = 2
+	MovDestExec MovDest = 4
+	MovDestPC   MovDest = 5
+	MovDestISR  MovDest = 6
+	MovDestOSR  MovDest = 7
+)
+
+// MovSrc is the source of a MOV instruction.
+type MovSrc uint8
+
+const (
+	MovSrcPins   MovSrc = 0
+	MovSrcX      MovSrc = 1
+	MovSrcY      MovSrc = 2
+	MovSrcNull   MovSrc = 3
+	MovSrcStatus MovSrc = 5
+	MovSrcISR    MovSrc = 6
+	MovSrcOSR    MovSrc = 7
+)
+
+// MovOp is the operation applied by a MOV instruction to the copied value.
+type MovOp uint8
+
+const (
+	MovNone    MovOp = iota
+	MovInvert        // Bitwise complement (!).
+	MovReverse       // Bit reversal (::).
+)
+
+// SetDest is the destination of a SET instruction.
+type SetDest uint8
+
+const (
+	SetPins    SetDest = 0
+	SetX       SetDest = 1
+	SetY       SetDest = 2
+	SetPindirs SetDest = 4
+)
+
+// Instruction opcodes, in the top 3 bits.
+const (
+	instrBitsJmp  = 0x0000
+	instrBitsWait = 0x2000
+	instrBitsIn   = 0x4000
+	instrBitsOut  = 0x6000
+	instrBitsPush = 0x8000
+	instrBitsPull = 0x8080
+	instrBitsMov  = 0xa000
+	instrBitsIRQ  = 0xc000
+	instrBitsSet  = 0xe000
+)
+
+// Jmp encodes a JMP to addr taken if cond is true.
+func Jmp(cond JmpCond, addr uint8) uint16 {
+	return instrBitsJmp | uint16(cond&7)<<5 | uint16(addr&0x1f)
+}
+
+// Wait encodes a WAIT for the GPIO, pin or IRQ flag index to be at polarity.
+func Wait(polarity bool, src WaitSrc, index uint8) uint16 {
+	return instrBitsWait | bit(polarity)<<7 | uint16(src&3)<<5 | uint16(index&0x1f)
+}
+
+// In encodes an IN shifting bitCount bits from src into the ISR.
+func In(src InSrc, bitCount uint8) uint16 {
+	return instrBitsIn | uint16(src&7)<<5 | uint16(bitCount&0x1f)
+}
+
+// Out encodes an OUT shifting bitCount bits from the OSR to dest.
+func Out(dest OutDest, bitCount uint8) uint16 {
+	return instrBitsOut | uint16(dest&7)<<5 | uint16(bitCount&0x1f)
+}
+
+// Push encodes a PUSH of the ISR to the RX FIFO.
+func Push(ifFull, block bool) uint16 {
+	return instrBitsPush | bit(ifFull)<<6 | bit(block)<<5
+}
+
+// Pull encodes a PULL from the TX FIFO to the OSR.
+func Pull(ifEmpty, block bool) uint16 {
+	return instrBitsPull | bit(ifEmpty)<<6 | bit(block)<<5
+}
+
+// Mov encodes a MOV copying src to dest with op applied.
+func Mov(dest MovDest, op MovOp, src MovSrc) uint16 {
+	return instrBitsMov | uint16(dest&7)<<5 | uint16(op&3)<<3 | uint16(src&7)
+}
+
+// IRQSet encodes an IRQ setting flag irq.
+func IRQSet(irq uint8, wait, relative bool) uint16 {
+	return instrBitsIRQ | bit(wait)<<5 | irqIndex(irq, relative)
+}
+
+// IRQClear encodes an IRQ clearing flag irq.
+func IRQClear(irq uint8, relative bool) uint16 {
+	return instrBitsIRQ | 1<<6 | irqIndex(irq, relative)
+}
+
+func irqIndex(irq uint8, relative bool) uint16 {
+	return bit(relative)<<4 | uint16(irq&7)
+}
+
+// Set encodes a SET writing value to dest.
+func Set(dest SetDest, value uint8) uint16 {
+	return instrBitsSet | uint16(dest&7)<<5 | uint16(value&0x1f)
+}
+
+// Nop encodes a NOP, assembled as mov y, y.
+func Nop() uint16 {
+	return Mov(MovDestY, MovNone, MovSrcY)
+}
+
+// Delay encodes a delay of cycles after the instruction.
+func Delay(cycles uint8) uint16 {
+	return uint16(cycles&0x1f) << 8
+}
+
+// SideSet encodes the side-set value of an instruction for bitCount mandatory
+// side-set bits.
+func SideSet(bitCount, value uint8) uint16 {
+	return uint16(value) << (13 - bitCount) & 0x1f00
+}
+
+// SideSetOpt encodes the side-set value of an instruction for bitCount
+// optional side-set bits, not counting the enable bit.
+func SideSetOpt(bitCount, value uint8) uint16 {
+	return 0x1000 | uint16(value)<<(12-bitCount)&0x0f00
+}
+
+func bit(b bool) uint16 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,126 @@
+package rp2040pio
+
+import "testing"
+
+// Programs from pico-examples, with the encodings of the .pio.h headers
+// generated by pioasm.
+func TestPrograms(t *testing.T) {
+	tests := []struct {
+		name string
+		got  []uint16
+		want []uint16
+	}{
+		{
+			// .side_set 1, T1 = 2, T2 = 5, T3 = 3.
+			name: "ws2812",
+			got: []uint16{
+				Out(OutX, 1) | SideSet(1, 0) | Delay(2),      // out x, 1 side 0 [T3 - 1]
+				Jmp(JmpXZero, 3) | SideSet(1, 1) | Delay(1),  // jmp !x do_zero side 1 [T1 - 1]
+				Jmp(JmpAlways, 0) | SideSet(1, 1) | Delay(4), // jmp bitloop side 1 [T2 - 1]
+				Nop() | SideSet(1, 0) | Delay(4),             // nop side 0 [T2 - 1]
+			},
+			want: []uint16{0x6221, 0x1123, 0x1400, 0xa442},
+		},
+		{
+			// .side_set 1
+			name: "spi_cpha0",
+			got: []uint16{
+				Out(OutPins, 1) | SideSet(1, 0) | Delay(1), // out pins, 1 side 0 [1]
+				In(InPins, 1) | SideSet(1, 1) | Delay(1),   // in pins, 1 side 1 [1]
+			},
+			want: []uint16{0x6101, 0x5101},
+		},
+		{
+			// .side_set 1
+			name: "spi_cpha1",
+			got: []uint16{
+				Out(OutX, 1) | SideSet(1, 0),                                  // out x, 1 side 0
+				Mov(MovDestPins, MovNone, MovSrcX) | SideSet(1, 1) | Delay(1), // mov pins, x side 1 [1]
+				In(InPins, 1) | SideSet(1, 0),                                 // in pins, 1 side 0
+			},
+			want: []uint16{0x6021, 0xb101, 0x4001},
+		},
+		{
+			// .side_set 2
+			name: "spi_cpha0_cs",
+			got: []uint16{
+				Out(OutPins, 1) | SideSet(2, 0) | Delay(1),      // out pins, 1 side 0x0 [1]
+				In(InPins, 1) | SideSet(2, 1),                   // in pins, 1 side 0x1
+				Jmp(JmpXNotZeroDec, 0) | SideSet(2, 1),          // jmp x-- bitloop side 0x1
+				Out(OutPins, 1) | SideSet(2, 0),                 // out pins, 1 side 0x0
+				Mov(MovDestX, MovNone, MovSrcY) | SideSet(2, 0), // mov x, y side 0x0
+				In(InPins, 1) | SideSet(2, 1),                   // in pins, 1 side 0x1
+				Jmp(JmpOSRNotEmpty, 0) | SideSet(2, 1),          // jmp !osre bitloop side 0x1
+				Nop() | SideSet(2, 0) | Delay(1),                // nop side 0x0 [1]
+				Pull(true, true) | SideSet(2, 2) | Delay(1),     // pull ifempty side 0x2 [1]
+			},
+			want: []uint16{0x6101, 0x4801, 0x0840, 0x6001, 0xa022, 0x4801, 0x08e0, 0xa142, 0x91e0},
+		},
+		{
+			// Glitch filter of package machine, against its former hand
+			// assembled encoding, checked with the datasheet formats.
+			name: "glitch filter",
+			got: []uint16{
+				Jmp(JmpPin, 6),                  // jmp pin, 6
+				Wait(true, WaitPin, 0),          // wait 1 pin, 0
+				Mov(MovDestX, MovNone, MovSrcY), // mov x, y
+				Jmp(JmpPin, 5),                  // jmp pin, 5
+				Jmp(JmpAlways, 1),               // jmp 1
+				Jmp(JmpXNotZeroDec, 3),          // jmp x--, 3
+				Wait(false, WaitPin, 0),         // wait 0 pin, 0
+				Mov(MovDestX, MovNone, MovSrcY), // mov x, y
+				Jmp(JmpPin, 6),                  // jmp pin, 6
+				Jmp(JmpXNotZeroDec, 8),          // jmp x--, 8
+				Jmp(JmpAlways, 1),               // jmp 1
+			},
+			want: []uint16{0x00c6, 0x20a0, 0xa022, 0x00c5, 0x0001, 0x0043, 0x2020, 0xa022, 0x00c6, 0x0048, 0x0001},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if len(tt.got) != len(tt.want) {
+				t.Fatalf("got %d instructions, want %d", len(tt.got), len(tt.want))
+			}
+			for i := range tt.got {
+				if tt.got[i] != tt.want[i] {
+					t.Errorf("instruction %d: got %#04x, want %#04x", i, tt.got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// Instructions not used by the programs above, encoded by hand from the
+// instruction formats of the datasheet.
+func TestInstructions(t *testing.T) {
+	tests := []struct {
+		asm  string
+		got  uint16
+		want uint16
+	}{
+		{"jmp pin 31", Jmp(JmpPin, 31), 0x00df},
+		{"wait 1 gpio 5", Wait(true, WaitGPIO, 5), 0x2085},
+		{"wait 0 pin 2", Wait(false, WaitPin, 2), 0x2022},
+		{"wait 1 irq 0 rel", Wait(true, WaitIRQ, 0x10), 0x20d0},
+		{"in null, 32", In(InNull, 32), 0x4060},
+		{"out exec, 16", Out(OutExec, 16), 0x60f0},
+		{"push block", Push(false, true), 0x8020},
+		{"push iffull noblock", Push(true, false), 0x8040},
+		{"pull noblock", Pull(false, false), 0x8080},
+		{"mov osr, ::isr", Mov(MovDestOSR, MovReverse, MovSrcISR), 0xa0f6},
+		{"mov x, ~status", Mov(MovDestX, MovInvert, MovSrcStatus), 0xa02d},
+		{"irq wait 0 rel", IRQSet(0, true, true), 0xc030},
+		{"irq nowait 7", IRQSet(7, false, false), 0xc007},
+		{"irq clear 3", IRQClear(3, false), 0xc043},
+		{"set pindirs, 1", Set(SetPindirs, 1), 0xe081},
+		{"set x, 31 [31]", Set(SetX, 31) | Delay(31), 0xff3f},
+		{"nop side 1 (.side_set 1 opt)", Nop() | SideSetOpt(1, 1), 0xb842},
+		{"nop (.side_set 1 opt)", Nop(), 0xa042},
+		{"nop side 3 [3] (.side_set 2 opt)", Nop() | SideSetOpt(2, 3) | Delay(3), 0xbf42},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %#04x, want %#04x", tt.asm, tt.got, tt.want)
+		}
+	}
+}
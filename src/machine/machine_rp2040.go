@@ -4,6 +4,8 @@ package machine
 
 import (
 	"device/rp"
+	"errors"
+	"runtime/interrupt"
 	"runtime/volatile"
 	"unsafe"
 )
@@ -116,22 +118,61 @@ func ChipVersion() uint8 {
 	return uint8(version)
 }
 
-// Single DMA channel. See rp.DMA_Type.
-type dmaChannel struct {
+// DMAChannel is a single DMA channel. See rp.DMA_Type.
+//
+// Channels should be claimed with ClaimDMAChannel before use so that they are
+// not shared by accident between peripheral drivers and user code.
+type DMAChannel struct {
 	READ_ADDR   volatile.Register32
 	WRITE_ADDR  volatile.Register32
 	TRANS_COUNT volatile.Register32
 	CTRL_TRIG   volatile.Register32
-	_           [12]volatile.Register32 // aliases
+	AL1_CTRL    volatile.Register32     // CTRL_TRIG alias which does not start the channel
+	_           [11]volatile.Register32 // other aliases
 }
 
 // Static assignment of DMA channels to peripherals.
-// Allocating them statically is good enough for now. If lots of peripherals use
-// DMA, these might need to be assigned at runtime.
+// These are used by peripheral drivers when no channel was passed in
+// explicitly (see SPI.SetDMAChannel) and are never returned by ClaimDMAChannel.
 const (
 	spi0DMAChannel = iota
 	spi1DMAChannel
 )
 
+// Number of DMA channels on the RP2040.
+const _NUMDMACHANNELS = 12
+
 // DMA channels usable on the RP2040.
-var dmaChannels = (*[12]dmaChannel)(unsafe.Pointer(rp.DMA))
+var dmaChannels = (*[_NUMDMACHANNELS]DMAChannel)(unsafe.Pointer(rp.DMA))
+
+// Bitmask of claimed DMA channels.
+var dmaChannelsClaimed uint32 = 1<<spi0DMAChannel | 1<<spi1DMAChannel
+
+var errNoDMAChannel = errors.New("no DMA channel available")
+
+// ClaimDMAChannel claims an unused DMA channel. The channel can be returned to
+// the pool with Unclaim once it is no longer in use.
+func ClaimDMAChannel() (*DMAChannel, error) {
+	state := interrupt.Disable()
+	defer interrupt.Restore(state)
+	for i := range dmaChannels {
+		if dmaChannelsClaimed&(1<<i) == 0 {
+			dmaChannelsClaimed |= 1 << i
+			return &dmaChannels[i], nil
+		}
+	}
+	return nil, errNoDMAChannel
+}
+
+// Unclaim returns the DMA channel to the pool of channels available to
+// ClaimDMAChannel. The channel must not be busy.
+func (ch *DMAChannel) Unclaim() {
+	state := interrupt.Disable()
+	dmaChannelsClaimed &^= 1 << ch.Index()
+	interrupt.Restore(state)
+}
+
+// Index returns the number of the DMA channel, in the range 0..11.
+func (ch *DMAChannel) Index() uint8 {
+	return uint8((uintptr(unsafe.Pointer(ch)) - uintptr(unsafe.Pointer(rp.DMA))) / unsafe.Sizeof(DMAChannel{}))
+}
@@ -92,16 +92,61 @@ func (c ADCChannel) Configure(config ADCConfig) error {
 func (c ADCChannel) getOnce() uint16 {
 	// Make it safe to sample multiple ADC channels in separate go routines.
 	adcLock.Lock()
+	v := c.getOnceLocked()
+	adcLock.Unlock()
+	return v
+}
+
+// getOnceLocked is getOnce for callers which already hold adcLock.
+func (c ADCChannel) getOnceLocked() uint16 {
 	rp.ADC.CS.ReplaceBits(uint32(c), 0b111, rp.ADC_CS_AINSEL_Pos)
 	rp.ADC.CS.SetBits(rp.ADC_CS_START_ONCE)
 
 	waitForReady()
-	adcLock.Unlock()
 
 	// rp2040 is a 12-bit ADC, scale raw reading to 16-bits.
 	return uint16(rp.ADC.RESULT.Get()) << 4
 }
 
+// ADCSample is a conversion result of ReadADCTimestamped.
+type ADCSample struct {
+	Channel ADCChannel
+	// Value is scaled to 16 bits like the result of ADC.Get.
+	Value uint16
+	// Time is the start of the conversion in microseconds since boot, on
+	// the same timer as the runtime.
+	Time uint64
+}
+
+// ReadADCTimestamped samples each of channels once, in order, and stores the
+// results in samples along with the time of each conversion, which is needed
+// to compute derivatives of the readings. It returns the filled part of
+// samples, which is the shorter of samples and channels; nothing is allocated.
+//
+// Conversions are started one at a time from a software loop: each takes 2us
+// at the usual 48MHz ADC clock, and the next one is only started once the
+// loop has read the result, selected the next channel and read the timer. The
+// spacing between conversions is therefore a little over 2us and not fixed,
+// and interrupts, which are not disabled, can delay a conversion further; the
+// timestamps, read just before each conversion starts, reflect the actual
+// spacing to within the 1us resolution of the timer.
+//
+// Channels are obtained with ADC.GetADCChannel and their pins must be
+// configured.
+func ReadADCTimestamped(channels []ADCChannel, samples []ADCSample) []ADCSample {
+	n := len(channels)
+	if len(samples) < n {
+		n = len(samples)
+	}
+	adcLock.Lock()
+	for i, c := range channels[:n] {
+		t := ticks()
+		samples[i] = ADCSample{Channel: c, Value: c.getOnceLocked(), Time: t}
+	}
+	adcLock.Unlock()
+	return samples[:n]
+}
+
 // getVoltage does a one-shot sample and returns a millivolts reading.
 // Integer portion is stored in the high 16 bits and fractional in the low 16 bits.
 func (c ADCChannel) getVoltage() uint32 {
@@ -0,0 +1,153 @@
+//go:build rp2040
+
+package machine
+
+import (
+	"device/rp"
+	"errors"
+	"runtime/interrupt"
+	"unsafe"
+)
+
+var (
+	errADCSampleRate  = errors.New("ADC sample rate must be in 733..500000")
+	errADCBufferSize  = errors.New("ADC buffers must be non-empty and of equal length")
+	errADCCaptureBusy = errors.New("ADC capture already running")
+)
+
+// Frequency of clkADC as configured by the clocks package.
+const adcClock = 48 * MHz
+
+// adcCapture is the state of the continuous capture started with
+// StartDoubleBuffered.
+var adcCapture struct {
+	ch   [2]*DMAChannel
+	buf  [2][]uint16
+	cb   func(full []uint16)
+	busy bool
+}
+
+// StartDoubleBuffered starts continuous sampling of the ADC pin at rate
+// samples per second into two buffers used in turns: while DMA fills one,
+// the application processes the other, so no samples are lost between
+// buffers. cb is called from the DMA interrupt with the buffer just filled,
+// and must return before the other buffer is filled.
+//
+// Samples are the raw 12-bit conversion results, unlike the 16-bit values
+// returned by Get. Two DMA channels are claimed, and Get on any ADC pin
+// blocks, until StopCapture is called.
+func (a ADC) StartDoubleBuffered(bufA, bufB []uint16, rate uint32, cb func(full []uint16)) error {
+	c, err := a.GetADCChannel()
+	if err != nil {
+		return err
+	}
+	if len(bufA) == 0 || len(bufA) != len(bufB) {
+		return errADCBufferSize
+	}
+	// Sample period in 16.8 fixed point clkADC cycles, minus one as per the
+	// DIV register definition. A conversion takes 96 cycles at minimum.
+	if rate == 0 || rate > adcClock/96 {
+		return errADCSampleRate
+	}
+	div := uint64(adcClock)*256/uint64(rate) - 256
+	if div>>8 > 0xffff {
+		return errADCSampleRate
+	}
+	if adcCapture.busy || activeComparator != nil {
+		return errADCCaptureBusy
+	}
+	var chs [2]*DMAChannel
+	for i := range chs {
+		chs[i], err = ClaimDMAChannel()
+		if err != nil {
+			if i > 0 {
+				chs[0].Unclaim()
+			}
+			return err
+		}
+	}
+	adcCapture.ch = chs
+	adcCapture.buf = [2][]uint16{bufA, bufB}
+	adcCapture.cb = cb
+	adcCapture.busy = true
+
+	adcLock.Lock()
+	rp.ADC.CS.ReplaceBits(uint32(c), 0b111, rp.ADC_CS_AINSEL_Pos)
+	rp.ADC.DIV.Set(uint32(div))
+	// Raise DREQ as soon as a sample is in the FIFO.
+	rp.ADC.FCS.Set(rp.ADC_FCS_EN | rp.ADC_FCS_DREQ_EN | 1<<rp.ADC_FCS_THRESH_Pos)
+
+	const dreqADC = 36
+	// Set up the second channel first as the first one starts right away.
+	for i := len(chs) - 1; i >= 0; i-- {
+		ch, other := chs[i], chs[1-i]
+		ch.READ_ADDR.Set(uint32(uintptr(unsafe.Pointer(&rp.ADC.FIFO))))
+		ch.WRITE_ADDR.Set(uint32(uintptr(unsafe.Pointer(&adcCapture.buf[i][0]))))
+		ch.TRANS_COUNT.Set(uint32(len(bufA)))
+		ctrl := rp.DMA_CH0_CTRL_TRIG_INCR_WRITE |
+			rp.DMA_CH0_CTRL_TRIG_DATA_SIZE_SIZE_HALFWORD<<rp.DMA_CH0_CTRL_TRIG_DATA_SIZE_Pos |
+			dreqADC<<rp.DMA_CH0_CTRL_TRIG_TREQ_SEL_Pos |
+			uint32(other.Index())<<rp.DMA_CH0_CTRL_TRIG_CHAIN_TO_Pos |
+			rp.DMA_CH0_CTRL_TRIG_EN
+		if i == 0 {
+			ch.CTRL_TRIG.Set(ctrl) // Starts the first buffer.
+		} else {
+			// Write the control register through an alias which does not
+			// trigger the channel, it is started by the chain.
+			ch.AL1_CTRL.Set(ctrl)
+		}
+	}
+	rp.DMA.INTS0.Set(1<<chs[0].Index() | 1<<chs[1].Index())
+	rp.DMA.INTE0.SetBits(1<<chs[0].Index() | 1<<chs[1].Index())
+	interrupt.New(rp.IRQ_DMA_IRQ_0, adcHandleDMAInterrupt).Enable()
+
+	rp.ADC.CS.SetBits(rp.ADC_CS_START_MANY)
+	return nil
+}
+
+// StopCapture stops a capture started with StartDoubleBuffered and releases
+// its DMA channels. The buffer being filled is left partially written.
+func (a ADC) StopCapture() {
+	if !adcCapture.busy {
+		return
+	}
+	rp.ADC.CS.ClearBits(rp.ADC_CS_START_MANY)
+	waitForReady()
+	for _, ch := range adcCapture.ch {
+		rp.DMA.INTE0.ClearBits(1 << ch.Index())
+		// Clearing EN pauses the channel; abort any transfer in flight.
+		ch.AL1_CTRL.ClearBits(rp.DMA_CH0_CTRL_TRIG_EN)
+		rp.DMA.CHAN_ABORT.Set(1 << ch.Index())
+		for rp.DMA.CHAN_ABORT.Get()&(1<<ch.Index()) != 0 {
+		}
+		ch.Unclaim()
+	}
+	// Drain and disable the FIFO.
+	rp.ADC.FCS.Set(0)
+	for rp.ADC.FCS.Get()&rp.ADC_FCS_EMPTY == 0 {
+		rp.ADC.FIFO.Get()
+	}
+	rp.ADC.DIV.Set(0)
+	adcCapture.ch = [2]*DMAChannel{}
+	adcCapture.busy = false
+	adcLock.Unlock()
+}
+
+func adcHandleDMAInterrupt(intr interrupt.Interrupt) {
+	for i, ch := range adcCapture.ch {
+		if ch == nil {
+			continue
+		}
+		mask := uint32(1) << ch.Index()
+		if rp.DMA.INTS0.Get()&mask == 0 {
+			continue
+		}
+		rp.DMA.INTS0.Set(mask)
+		// Rearm the channel for when the other one chains back to it.
+		ch.WRITE_ADDR.Set(uint32(uintptr(unsafe.Pointer(&adcCapture.buf[i][0]))))
+		ch.TRANS_COUNT.Set(uint32(len(adcCapture.buf[i])))
+		if adcCapture.cb != nil {
+			adcCapture.cb(adcCapture.buf[i])
+		}
+	}
+}
@@ -0,0 +1,80 @@
+//go:build rp2040
+
+package machine
+
+import (
+	"device/rp"
+	"errors"
+)
+
+var errCharlieplexPins = errors.New("charlieplex needs 2 to 30 distinct GPIO pins")
+
+// Charlieplex drives a charlieplexed LED matrix, where n pins drive n*(n-1)
+// LEDs by lighting one LED at a time: its anode pin is driven high, its
+// cathode pin low, and all other pins are left floating.
+//
+// LED i has anode pins[i/(n-1)] and cathode the (i%(n-1))-th of the other
+// pins, in order. To show several LEDs at once, light them in turn quickly
+// enough, over 100 times per second, for persistence of vision.
+type Charlieplex struct {
+	pins []Pin
+	// Mask of all pins in the SIO registers.
+	mask uint32
+	// lit is the LED currently on, -1 if none.
+	lit int
+}
+
+// NewCharlieplex configures pins as floating inputs and returns a Charlieplex
+// driving the matrix connected to them, with all LEDs off.
+func NewCharlieplex(pins []Pin) (*Charlieplex, error) {
+	if len(pins) < 2 {
+		return nil, errCharlieplexPins
+	}
+	var mask uint32
+	for _, p := range pins {
+		if p >= _NUMBANK0_GPIOS || mask&(1<<p) != 0 {
+			return nil, errCharlieplexPins
+		}
+		mask |= 1 << p
+	}
+	for _, p := range pins {
+		p.Configure(PinConfig{Mode: PinInput})
+	}
+	rp.SIO.GPIO_OUT_CLR.Set(mask)
+	return &Charlieplex{pins: pins, mask: mask, lit: -1}, nil
+}
+
+// Len returns the number of LEDs in the matrix.
+func (c *Charlieplex) Len() int {
+	n := len(c.pins)
+	return n * (n - 1)
+}
+
+// Set turns LED led on, turning off the LED lit before, or turns it off.
+// Turning off an LED which is not lit and out of range values do nothing.
+//
+// All pins are released before the new pair is driven, and each step is a
+// single register write, so no other LED lights up during the transition.
+func (c *Charlieplex) Set(led int, on bool) {
+	if led < 0 || led >= c.Len() {
+		return
+	}
+	if !on {
+		if led == c.lit {
+			rp.SIO.GPIO_OE_CLR.Set(c.mask)
+			c.lit = -1
+		}
+		return
+	}
+	n := len(c.pins) - 1
+	anode := c.pins[led/n]
+	cathode := led % n
+	if cathode >= led/n {
+		cathode++ // Skip the anode.
+	}
+	rp.SIO.GPIO_OE_CLR.Set(c.mask)
+	rp.SIO.GPIO_OUT_CLR.Set(c.mask)
+	rp.SIO.GPIO_OUT_SET.Set(1 << anode)
+	rp.SIO.GPIO_OE_SET.Set(1<<anode | 1<<c.pins[cathode])
+	c.lit = led
+}
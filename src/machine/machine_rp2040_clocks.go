@@ -5,6 +5,7 @@ package machine
 import (
 	"device/arm"
 	"device/rp"
+	"errors"
 	"runtime/volatile"
 	"unsafe"
 )
@@ -235,12 +236,8 @@ func (clks *clocksType) init() {
 		48*MHz,
 		48*MHz)
 
-	// clkRTC = pllUSB (48MHz) / 1024 = 46875Hz
-	clkrtc := clks.clock(clkRTC)
-	clkrtc.configure(0, // No GLMUX
-		rp.CLOCKS_CLK_RTC_CTRL_AUXSRC_CLKSRC_PLL_USB,
-		48*MHz,
-		46875)
+	// clkRTC = pllUSB (48MHz) / 1024 = 46875Hz, unless set by SetRTCClockInput
+	clks.configureRTC(rp.CLOCKS_CLK_RTC_CTRL_AUXSRC_CLKSRC_PLL_USB, 48*MHz)
 
 	// clkPeri = clkSys. Used as reference clock for Peripherals.
 	// No dividers so just select and enable.
@@ -251,3 +248,196 @@ func (clks *clocksType) init() {
 		125*MHz,
 		125*MHz)
 }
+
+// configureRTC runs clkRTC at 46875Hz from auxsrc, of frequency srcFreq, or
+// from the external clock selected with SetRTCClockInput if any.
+func (clks *clocksType) configureRTC(auxsrc, srcFreq uint32) {
+	clkrtc := clks.clock(clkRTC)
+	if rtcClockInputFreq != 0 {
+		clkrtc.configure(0, // No GLMUX
+			rtcClockInputAuxsrc,
+			rtcClockInputFreq,
+			rtcClockInputFreq)
+		return
+	}
+	clkrtc.configure(0, // No GLMUX
+		auxsrc,
+		srcFreq,
+		46875)
+}
+
+// stop cleanly stops the clock. Not applicable to clkRef and clkSys, which
+// have no enable bit.
+func (clk *clock) stop() {
+	clk.ctrl.ClearBits(rp.CLOCKS_CLK_GPOUT0_CTRL_ENABLE_Msk)
+	configuredFreq[clk.cix] = 0
+}
+
+// runFromXOSC switches every running clock to the crystal oscillator and
+// powers down the PLLs, which is required before entering dormant mode.
+// clkUSB and clkADC are stopped. Call init to restore the regular clock
+// configuration.
+func (clks *clocksType) runFromXOSC() {
+	const freq = xoscFreq * MHz
+
+	// clkRef = xosc (12MHz) / 1 = 12MHz
+	clkref := clks.clock(clkRef)
+	clkref.configure(rp.CLOCKS_CLK_REF_CTRL_SRC_XOSC_CLKSRC,
+		0, // No aux mux
+		freq,
+		freq)
+
+	// clkSys = clkRef (12MHz) / 1 = 12MHz
+	clksys := clks.clock(clkSys)
+	clksys.configure(rp.CLOCKS_CLK_SYS_CTRL_SRC_CLK_REF,
+		0, // Aux mux not in use
+		freq,
+		freq)
+
+	clkusb := clks.clock(clkUSB)
+	clkusb.stop()
+	clkadc := clks.clock(clkADC)
+	clkadc.stop()
+
+	// clkRTC = xosc (12MHz) / 256 = 46875Hz, unless set by SetRTCClockInput
+	clks.configureRTC(rp.CLOCKS_CLK_RTC_CTRL_AUXSRC_XOSC_CLKSRC, freq)
+
+	// clkPeri = clkSys (12MHz).
+	clkperi := clks.clock(clkPeri)
+	clkperi.configure(0,
+		rp.CLOCKS_CLK_PERI_CTRL_AUXSRC_CLK_SYS,
+		freq,
+		freq)
+
+	pllSys.deinit()
+	pllUSB.deinit()
+}
+
+// ClockSource is a clock whose frequency can be measured with
+// MeasureFrequency.
+type ClockSource uint8
+
+// Clock sources of the frequency counter.
+const (
+	ClockSourcePLLSys ClockSource = rp.CLOCKS_FC0_SRC_FC0_SRC_PLL_SYS_CLKSRC_PRIMARY
+	ClockSourcePLLUSB ClockSource = rp.CLOCKS_FC0_SRC_FC0_SRC_PLL_USB_CLKSRC_PRIMARY
+	ClockSourceROSC   ClockSource = rp.CLOCKS_FC0_SRC_FC0_SRC_ROSC_CLKSRC
+	ClockSourceXOSC   ClockSource = rp.CLOCKS_FC0_SRC_FC0_SRC_XOSC_CLKSRC
+	ClockSourceGPIN0  ClockSource = rp.CLOCKS_FC0_SRC_FC0_SRC_CLKSRC_GPIN0
+	ClockSourceGPIN1  ClockSource = rp.CLOCKS_FC0_SRC_FC0_SRC_CLKSRC_GPIN1
+	ClockSourceRef    ClockSource = rp.CLOCKS_FC0_SRC_FC0_SRC_CLK_REF
+	ClockSourceSys    ClockSource = rp.CLOCKS_FC0_SRC_FC0_SRC_CLK_SYS
+	ClockSourcePeri   ClockSource = rp.CLOCKS_FC0_SRC_FC0_SRC_CLK_PERI
+	ClockSourceUSB    ClockSource = rp.CLOCKS_FC0_SRC_FC0_SRC_CLK_USB
+	ClockSourceADC    ClockSource = rp.CLOCKS_FC0_SRC_FC0_SRC_CLK_ADC
+	ClockSourceRTC    ClockSource = rp.CLOCKS_FC0_SRC_FC0_SRC_CLK_RTC
+	numClockSources               = ClockSourceRTC + 1
+)
+
+var (
+	errInvalidClockSource = errors.New("invalid clock source")
+	errFrequencyTimeout   = errors.New("frequency counter timeout")
+	errClockStopped       = errors.New("measured clock is not running")
+)
+
+// MeasureFrequency measures the frequency of src in kHz with the frequency
+// counter of the clocks block, using clkRef as the reference. A measurement
+// takes about 1ms and is accurate to about 1kHz. An error is returned if
+// the clock is stopped or the counter does not finish in time.
+//
+// This is useful to check the PLL and ROSC settings.
+func MeasureFrequency(src ClockSource) (khz uint32, err error) {
+	if src == 0 || src >= numClockSources {
+		return 0, errInvalidClockSource
+	}
+	fc := &clocks.fc0
+	const running = rp.CLOCKS_FC0_STATUS_RUNNING | rp.CLOCKS_FC0_STATUS_WAITING
+	for fc.status.Get()&running != 0 {
+	}
+	fc.refKHz.Set(configuredFreq[clkRef] / 1000)
+	// The count lasts 2^interval reference cycles of 0.98us, ~1ms.
+	fc.interval.Set(10)
+	fc.minKHz.Set(0)
+	fc.maxKHz.Set(0xffffffff)
+	// Writing the source starts the measurement.
+	fc.src.Set(uint32(src))
+
+	deadline := ticks() + 10_000
+	for fc.status.Get()&rp.CLOCKS_FC0_STATUS_DONE == 0 {
+		if ticks() > deadline {
+			return 0, errFrequencyTimeout
+		}
+	}
+	if fc.status.Get()&rp.CLOCKS_FC0_STATUS_DIED != 0 {
+		return 0, errClockStopped
+	}
+	return (fc.result.Get() & rp.CLOCKS_FC0_RESULT_KHZ_Msk) >> rp.CLOCKS_FC0_RESULT_KHZ_Pos, nil
+}
+
+// Peripheral is a hardware block whose clocks can be gated with
+// SetPeripheralClock.
+type Peripheral uint8
+
+// Peripherals whose clocks can be gated.
+const (
+	PeripheralADC Peripheral = iota
+	PeripheralDMA
+	PeripheralI2C0
+	PeripheralI2C1
+	PeripheralPIO0
+	PeripheralPIO1
+	PeripheralPWM
+	PeripheralRTC
+	PeripheralSPI0
+	PeripheralSPI1
+	PeripheralTimer
+	PeripheralUART0
+	PeripheralUART1
+	PeripheralUSB
+	numPeripherals
+)
+
+// Bits of each peripheral in the WAKE_EN0/SLEEP_EN0 and WAKE_EN1/SLEEP_EN1
+// registers. Most peripherals have a bus clock (clk_sys) and some also a
+// clock for their logic (clk_peri, clk_adc, clk_rtc or clk_usb).
+var peripheralClockBits = [numPeripherals][2]uint32{
+	PeripheralADC:   {1<<1 | 1<<2, 0},
+	PeripheralDMA:   {1 << 5, 0},
+	PeripheralI2C0:  {1 << 6, 0},
+	PeripheralI2C1:  {1 << 7, 0},
+	PeripheralPIO0:  {1 << 12, 0},
+	PeripheralPIO1:  {1 << 13, 0},
+	PeripheralPWM:   {1 << 17, 0},
+	PeripheralRTC:   {1<<21 | 1<<22, 0},
+	PeripheralSPI0:  {1<<24 | 1<<25, 0},
+	PeripheralSPI1:  {1<<26 | 1<<27, 0},
+	PeripheralTimer: {0, 1 << 5},
+	PeripheralUART0: {0, 1<<6 | 1<<7},
+	PeripheralUART1: {0, 1<<8 | 1<<9},
+	PeripheralUSB:   {0, 1<<10 | 1<<11},
+}
+
+// SetPeripheralClock enables or disables the clocks of a peripheral, both
+// while the processors run and while they sleep, to save power on blocks
+// that are not in use. All clocks are enabled at reset.
+//
+// A peripheral must be idle when its clocks are stopped and keeps its state
+// until they are enabled again. Stopping the timer clock stops ticks and
+// sleeps, which the runtime relies on.
+func SetPeripheralClock(p Peripheral, enabled bool) {
+	if p >= numPeripherals {
+		return
+	}
+	bits := peripheralClockBits[p]
+	if enabled {
+		clocks.wakeEN0.SetBits(bits[0])
+		clocks.wakeEN1.SetBits(bits[1])
+		clocks.sleepEN0.SetBits(bits[0])
+		clocks.sleepEN1.SetBits(bits[1])
+	} else {
+		clocks.wakeEN0.ClearBits(bits[0])
+		clocks.wakeEN1.ClearBits(bits[1])
+		clocks.sleepEN0.ClearBits(bits[0])
+		clocks.sleepEN1.ClearBits(bits[1])
+	}
+}
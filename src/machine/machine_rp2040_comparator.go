@@ -0,0 +1,128 @@
+//go:build rp2040
+
+package machine
+
+import (
+	"device/rp"
+	"errors"
+	"runtime/interrupt"
+	"runtime/volatile"
+)
+
+// The RP2040 has no analog comparator. Comparator emulates one with the ADC
+// running conversions continuously and comparing every sample against the
+// threshold from the ADC interrupt.
+
+var errComparatorBusy = errors.New("ADC already in use by a comparator")
+
+const (
+	// Rate at which the comparator input is sampled.
+	comparatorSampleRate = 10_000
+	// Hysteresis around the threshold, in raw 12-bit ADC counts (about 20mV
+	// with a 3.3V reference), so noise does not cause repeated edges.
+	comparatorHysteresis = 24
+)
+
+// Comparator compares the voltage on an ADC pin to a threshold. See
+// NewComparator.
+type Comparator struct {
+	pin       Pin
+	threshold uint16 // raw 12-bit ADC value
+	above     volatile.Register8
+	callback  func(above bool)
+}
+
+// The comparator serviced by the ADC interrupt.
+var activeComparator *Comparator
+
+// NewComparator starts comparing the voltage on pin, which must be an ADC
+// pin, to thresholdMV millivolts. The comparison is emulated with the ADC:
+// the pin is sampled 10000 times per second, so edges are detected with up to
+// 100us latency and pulses shorter than that may be missed. A hysteresis of
+// about 20mV applies around the threshold.
+//
+// The ADC is used exclusively by the comparator until Close is called; ADC
+// Get calls block until then.
+func NewComparator(pin Pin, thresholdMV uint16) (*Comparator, error) {
+	c, err := ADC{Pin: pin}.GetADCChannel()
+	if err != nil {
+		return nil, err
+	}
+	if activeComparator != nil || adcCapture.busy {
+		return nil, errComparatorBusy
+	}
+	if rp.ADC.CS.Get()&rp.ADC_CS_EN == 0 {
+		InitADC()
+	}
+	pin.Configure(PinConfig{Mode: PinAnalog})
+	threshold := uint32(thresholdMV) << 12 / adcAref
+	if threshold > 0xfff {
+		threshold = 0xfff
+	}
+	cmp := &Comparator{pin: pin, threshold: uint16(threshold)}
+
+	adcLock.Lock()
+	cmp.above.Set(uint8(boolToBit(c.getOnceLocked()>>4 > cmp.threshold)))
+	activeComparator = cmp
+
+	rp.ADC.DIV.Set((adcClock/comparatorSampleRate - 1) << rp.ADC_DIV_INT_Pos)
+	rp.ADC.FCS.Set(rp.ADC_FCS_EN | 1<<rp.ADC_FCS_THRESH_Pos)
+	rp.ADC.INTE.Set(rp.ADC_INTE_FIFO)
+	interrupt.New(rp.IRQ_ADC_IRQ_FIFO, adcHandleComparatorInterrupt).Enable()
+	rp.ADC.CS.SetBits(rp.ADC_CS_START_MANY)
+	return cmp, nil
+}
+
+// Above returns true if the voltage on the pin is above the threshold.
+func (cmp *Comparator) Above() bool {
+	return cmp.above.Get() != 0
+}
+
+// SetCallback sets a function to be called from the ADC interrupt every time
+// the voltage crosses the threshold, with the new result of Above. Pass nil to
+// remove the callback.
+func (cmp *Comparator) SetCallback(callback func(above bool)) {
+	state := interrupt.Disable()
+	cmp.callback = callback
+	interrupt.Restore(state)
+}
+
+// Close stops the comparator and releases the ADC.
+func (cmp *Comparator) Close() {
+	if activeComparator != cmp {
+		return
+	}
+	rp.ADC.CS.ClearBits(rp.ADC_CS_START_MANY)
+	waitForReady()
+	rp.ADC.INTE.Set(0)
+	rp.ADC.FCS.Set(0)
+	for rp.ADC.FCS.Get()&rp.ADC_FCS_EMPTY == 0 {
+		rp.ADC.FIFO.Get()
+	}
+	rp.ADC.DIV.Set(0)
+	activeComparator = nil
+	adcLock.Unlock()
+}
+
+func adcHandleComparatorInterrupt(intr interrupt.Interrupt) {
+	cmp := activeComparator
+	for rp.ADC.FCS.Get()&rp.ADC_FCS_EMPTY == 0 {
+		sample := uint16(rp.ADC.FIFO.Get() & 0xfff)
+		if cmp == nil {
+			continue
+		}
+		above := cmp.above.Get() != 0
+		switch {
+		case !above && sample > cmp.threshold+comparatorHysteresis/2:
+			above = true
+		case above && sample+comparatorHysteresis/2 < cmp.threshold:
+			above = false
+		default:
+			continue
+		}
+		cmp.above.Set(uint8(boolToBit(above)))
+		if cmp.callback != nil {
+			cmp.callback(above)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+//go:build rp2040
+
+package machine
+
+import (
+	"device/rp"
+	"unsafe"
+)
+
+// DMASniffCalc is a calculation performed by the DMA sniffer on the data
+// flowing through a channel.
+type DMASniffCalc uint8
+
+// Calculations supported by the DMA sniffer. The CRC polynomials are fixed in
+// hardware: 0x04C11DB7 for CRC-32 and 0x1021 for CRC-16-CCITT. The reversed
+// variants bit-reverse each input byte first, as used by reflected CRCs.
+const (
+	DMASniffCRC32   DMASniffCalc = 0x0
+	DMASniffCRC32R  DMASniffCalc = 0x1
+	DMASniffCRC16   DMASniffCalc = 0x2
+	DMASniffCRC16R  DMASniffCalc = 0x3
+	DMASniffEvenXOR DMASniffCalc = 0xe
+	DMASniffSum     DMASniffCalc = 0xf
+)
+
+// DMASniffConfig configures a checksum calculation done with DMASniff.
+type DMASniffConfig struct {
+	Calc DMASniffCalc
+	// Seed is the initial value of the checksum.
+	Seed uint32
+	// OutReverse bit-reverses the result.
+	OutReverse bool
+	// OutInvert inverts the result.
+	OutInvert bool
+}
+
+// CRC32DMA returns the IEEE CRC-32 of data, as computed by hash/crc32, using
+// the DMA sniffer instead of the CPU.
+func CRC32DMA(data []byte) (uint32, error) {
+	return DMASniff(data, DMASniffConfig{
+		Calc:       DMASniffCRC32R,
+		Seed:       0xffffffff,
+		OutReverse: true,
+		OutInvert:  true,
+	})
+}
+
+// DMASniff runs data through a memory to memory DMA transfer with the sniffer
+// enabled and returns the resulting checksum. A DMA channel is claimed for the
+// duration of the call. Only one sniffer calculation can be in progress at a
+// time.
+func DMASniff(data []byte, cfg DMASniffConfig) (uint32, error) {
+	ch, err := ClaimDMAChannel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Unclaim()
+
+	rp.DMA.SNIFF_DATA.Set(cfg.Seed)
+	rp.DMA.SNIFF_CTRL.Set(uint32(ch.Index())<<rp.DMA_SNIFF_CTRL_DMACH_Pos |
+		uint32(cfg.Calc)<<rp.DMA_SNIFF_CTRL_CALC_Pos |
+		boolToBit(cfg.OutReverse)<<rp.DMA_SNIFF_CTRL_OUT_REV_Pos |
+		boolToBit(cfg.OutInvert)<<rp.DMA_SNIFF_CTRL_OUT_INV_Pos |
+		rp.DMA_SNIFF_CTRL_EN)
+	if len(data) > 0 {
+		// The data only needs to pass through the channel, so it is all
+		// written to the same word.
+		var sink uint32
+		ch.READ_ADDR.Set(uint32(uintptr(unsafe.Pointer(&data[0]))))
+		ch.WRITE_ADDR.Set(uint32(uintptr(unsafe.Pointer(&sink))))
+		ch.TRANS_COUNT.Set(uint32(len(data)))
+		ch.CTRL_TRIG.Set(rp.DMA_CH0_CTRL_TRIG_INCR_READ |
+			rp.DMA_CH0_CTRL_TRIG_DATA_SIZE_SIZE_BYTE<<rp.DMA_CH0_CTRL_TRIG_DATA_SIZE_Pos |
+			rp.DMA_CH0_CTRL_TRIG_TREQ_SEL_PERMANENT<<rp.DMA_CH0_CTRL_TRIG_TREQ_SEL_Pos |
+			uint32(ch.Index())<<rp.DMA_CH0_CTRL_TRIG_CHAIN_TO_Pos | // Don't chain.
+			rp.DMA_CH0_CTRL_TRIG_SNIFF_EN |
+			rp.DMA_CH0_CTRL_TRIG_EN)
+		for ch.CTRL_TRIG.Get()&rp.DMA_CH0_CTRL_TRIG_BUSY != 0 {
+		}
+	}
+	sum := rp.DMA.SNIFF_DATA.Get()
+	rp.DMA.SNIFF_CTRL.Set(0)
+	return sum, nil
+}
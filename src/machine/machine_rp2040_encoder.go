@@ -0,0 +1,77 @@
+//go:build rp2040
+
+package machine
+
+import "runtime/volatile"
+
+// Change of the encoder position by previous<<2 | current state, with the
+// state being A<<1 | B. Transitions where both signals changed are invalid,
+// an edge was missed, and count as no change.
+var encoderSteps = [16]int8{0, -1, 1, 0, 1, 0, 0, -1, -1, 0, 0, 1, 0, 1, -1, 0}
+
+// RotaryEncoder decodes a quadrature rotary encoder from pin change interrupts
+// on its A and B signals.
+//
+// Each edge is decoded from the previous and current levels of both signals
+// with a state table, so contact bounce moves the position back and forth by
+// one step and settles on the right value, and no debouncing is needed. The
+// position changes by one for every edge, which is 4 steps per cycle of the
+// signals; most encoders click once per cycle, or sometimes twice.
+type RotaryEncoder struct {
+	// OnChange, if set before Configure, is called with the new position
+	// from the pin interrupt handler every time it changes. The usual
+	// restrictions of interrupt handlers apply.
+	OnChange func(value int32)
+
+	a, b  Pin
+	state uint8
+	value volatile.Register32
+}
+
+// Configure sets up the encoder with its A and B signals on pinA and pinB,
+// configured as inputs with pull-ups for encoders switching to ground, and
+// sets their pin change interrupts. The position starts at 0 and increases
+// when A leads B; swap the pins to reverse the direction.
+//
+// An error is returned if either pin already has a pin change callback.
+func (e *RotaryEncoder) Configure(pinA, pinB Pin) error {
+	e.a, e.b = pinA, pinB
+	pinA.Configure(PinConfig{Mode: PinInputPullup})
+	pinB.Configure(PinConfig{Mode: PinInputPullup})
+	e.state = e.read()
+	e.value.Set(0)
+	err := pinA.SetInterrupt(PinRising|PinFalling, e.handleChange)
+	if err != nil {
+		return err
+	}
+	err = pinB.SetInterrupt(PinRising|PinFalling, e.handleChange)
+	if err != nil {
+		pinA.SetInterrupt(0, nil)
+	}
+	return err
+}
+
+// Value returns the position of the encoder, in edges since Configure.
+func (e *RotaryEncoder) Value() int32 {
+	return int32(e.value.Get())
+}
+
+// read returns the state of the signals, A<<1 | B.
+func (e *RotaryEncoder) read() uint8 {
+	return uint8(boolToBit(e.a.Get())<<1 | boolToBit(e.b.Get()))
+}
+
+// handleChange is the pin change callback of both pins.
+func (e *RotaryEncoder) handleChange(Pin) {
+	state := e.read()
+	step := encoderSteps[e.state<<2|state]
+	e.state = state
+	if step == 0 {
+		return
+	}
+	value := int32(e.value.Get()) + int32(step)
+	e.value.Set(uint32(value))
+	if e.OnChange != nil {
+		e.OnChange(value)
+	}
+}
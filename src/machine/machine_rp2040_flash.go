@@ -4,6 +4,7 @@ package machine
 
 import (
 	"bytes"
+	"device/rp"
 	"unsafe"
 )
 
@@ -95,3 +96,21 @@ func writeAddress(off int64) uintptr {
 func readAddress(off int64) uintptr {
 	return FlashDataStart() + uintptr(off)
 }
+
+// XIPCacheEnable enables or disables the 16kB cache in front of the flash
+// execute-in-place (XIP) window. With the cache disabled every instruction
+// and constant fetched from flash goes out over QSPI, which makes code running
+// from flash many times slower but its timing independent of cache hits.
+// Code in RAM is not affected.
+func XIPCacheEnable(enabled bool) {
+	rp.XIP_CTRL.CTRL.ReplaceBits(boolToBit(enabled)<<rp.XIP_CTRL_CTRL_EN_Pos, rp.XIP_CTRL_CTRL_EN_Msk, 0)
+}
+
+// XIPCacheFlush invalidates the XIP cache, so that subsequent reads through
+// the XIP window fetch fresh data from flash. Writes and erases done through
+// Flash already flush the cache.
+func XIPCacheFlush() {
+	rp.XIP_CTRL.FLUSH.Set(1)
+	// Reading FLUSH stalls until the flush is complete.
+	rp.XIP_CTRL.FLUSH.Get()
+}
@@ -4,8 +4,11 @@ package machine
 
 import (
 	"device/rp"
+	"errors"
+	"machine/internal/rp2040gpio"
 	"runtime/interrupt"
 	"runtime/volatile"
+	"time"
 	"unsafe"
 )
 
@@ -87,6 +90,14 @@ const (
 	PinSPI
 	PinPIO0
 	PinPIO1
+
+	// PinInputBusKeeper is an input with both the pull-up and the pull-down
+	// enabled. Together they form a weak bus keeper: the line is held at its
+	// last driven level once released, instead of floating to an undefined
+	// level and making the input buffer draw current. This is unusual, meant
+	// for buses or high impedance sensor outputs which are sometimes left
+	// undriven; a line that is never driven settles around half the supply.
+	PinInputBusKeeper
 )
 
 func (p Pin) PortMaskSet() (*uint32, uint32) {
@@ -128,6 +139,65 @@ func (p Pin) padCtrl() *volatile.Register32 {
 	return &padsBank0.io[p]
 }
 
+// PinState is a snapshot of the configuration of a pin taken by
+// SavePinState. It is a plain value which can be copied freely.
+type PinState struct {
+	ioCtrl  uint32
+	padCtrl uint32
+	// SIO output enable and level, used when the pin function is SIO.
+	oe, out bool
+}
+
+// SavePinState returns the configuration of p: its function, overrides, pad
+// settings and SIO output state. Together with RestorePinState it lets code
+// borrow a pin and put it back exactly as it was.
+func SavePinState(p Pin) PinState {
+	if p == NoPin || p >= _NUMBANK0_GPIOS {
+		return PinState{}
+	}
+	mask := uint32(1) << p
+	return PinState{
+		ioCtrl:  p.ioCtrl().Get(),
+		padCtrl: p.padCtrl().Get(),
+		oe:      rp.SIO.GPIO_OE.HasBits(mask),
+		out:     rp.SIO.GPIO_OUT.HasBits(mask),
+	}
+}
+
+// RestorePinState restores the configuration of p saved by SavePinState.
+func RestorePinState(p Pin, s PinState) {
+	if p == NoPin || p >= _NUMBANK0_GPIOS {
+		return
+	}
+	mask := uint32(1) << p
+	// Set the output level and direction before the function, so the pin
+	// does not glitch when it is switched back to SIO.
+	if s.out {
+		rp.SIO.GPIO_OUT_SET.Set(mask)
+	} else {
+		rp.SIO.GPIO_OUT_CLR.Set(mask)
+	}
+	if s.oe {
+		rp.SIO.GPIO_OE_SET.Set(mask)
+	} else {
+		rp.SIO.GPIO_OE_CLR.Set(mask)
+	}
+	p.padCtrl().Set(s.padCtrl)
+	p.ioCtrl().Set(s.ioCtrl)
+}
+
+// ConfigureAndSave configures p like Configure and returns its configuration
+// from before the call, to be restored with RestorePinState once done with the
+// pin:
+//
+//	saved := pin.ConfigureAndSave(machine.PinConfig{Mode: machine.PinOutput})
+//	defer machine.RestorePinState(pin, saved)
+func (p Pin) ConfigureAndSave(config PinConfig) PinState {
+	s := SavePinState(p)
+	p.Configure(config)
+	return s
+}
+
 func (p Pin) pullup() {
 	p.padCtrl().SetBits(rp.PADS_BANK0_GPIO0_PUE)
 	p.padCtrl().ClearBits(rp.PADS_BANK0_GPIO0_PDE)
@@ -138,6 +208,11 @@ func (p Pin) pulldown() {
 	p.padCtrl().ClearBits(rp.PADS_BANK0_GPIO0_PUE)
 }
 
+// pullboth enables both pulls, which makes a bus keeper.
+func (p Pin) pullboth() {
+	p.padCtrl().SetBits(rp.PADS_BANK0_GPIO0_PUE | rp.PADS_BANK0_GPIO0_PDE)
+}
+
 func (p Pin) pulloff() {
 	p.padCtrl().ClearBits(rp.PADS_BANK0_GPIO0_PDE)
 	p.padCtrl().ClearBits(rp.PADS_BANK0_GPIO0_PUE)
@@ -154,6 +229,154 @@ func (p Pin) setSchmitt(trigger bool) {
 	p.padCtrl().ReplaceBits(boolToBit(trigger)<<rp.PADS_BANK0_GPIO0_SCHMITT_Pos, rp.PADS_BANK0_GPIO0_SCHMITT_Msk, 0)
 }
 
+// Pad output drive strengths, values of the DRIVE field of the pad control
+// register.
+const (
+	padDrive2mA  = 0
+	padDrive4mA  = 1
+	padDrive8mA  = 2
+	padDrive12mA = 3
+)
+
+// setDrive sets the pad output drive strength to one of the padDrive values.
+func (p Pin) setDrive(drive uint32) {
+	p.padCtrl().ReplaceBits(drive<<rp.PADS_BANK0_GPIO0_DRIVE_Pos, rp.PADS_BANK0_GPIO0_DRIVE_Msk, 0)
+}
+
+// ConfigureHighSpeed sets the pad of the pin up for fast edges, for signals
+// such as high frequency SPI or PIO clocks: fast slew rate, 12mA drive and no
+// Schmitt trigger on the input. Other pad and function settings are left
+// unchanged. Fast edges cause more ringing and electromagnetic interference.
+func (p Pin) ConfigureHighSpeed() {
+	p.setSlew(true)
+	p.setDrive(padDrive12mA)
+	p.setSchmitt(false)
+}
+
+// ConfigureLowNoise sets the pad of the pin up for minimal interference, for
+// slow signals near sensitive analog circuitry: slow slew rate and 2mA drive.
+// The Schmitt trigger and other pad and function settings are left
+// unchanged.
+func (p Pin) ConfigureLowNoise() {
+	p.setSlew(false)
+	p.setDrive(padDrive2mA)
+}
+
+// AutoDriveStrength picks the lowest drive strength which switches the pin,
+// already configured as an output, about as fast as the strongest one, to
+// limit the interference caused by needlessly fast edges. The pin is set to
+// the chosen strength and its value in mA, 2, 4, 8 or 12, is returned.
+//
+// Edges are observed on feedback, which must be wired to the pin close to the
+// load, at the far end of the trace or cable being driven. feedback may be the
+// pin itself, whose input buffer then sees the edges at the chip; it must
+// otherwise be configured as an input without pulls. The pin is toggled a few
+// dozen times during the test, with interrupts disabled, and is left at its
+// previous level. If feedback doesn't follow the pin at the highest strength,
+// because of the wiring, 0 is returned and the drive strength is unchanged.
+//
+// Edges are timed by polling, with a resolution of a few tens of nanoseconds
+// at 125MHz, so only loads slowing edges beyond that make a difference.
+func (p Pin) AutoDriveStrength(feedback Pin) uint8 {
+	if p >= _NUMBANK0_GPIOS || feedback >= _NUMBANK0_GPIOS {
+		return 0
+	}
+	const maxPolls = 10000
+	mA := [...]uint8{2, 4, 8, 12}
+	level := p.GetOutput()
+	prevDrive := (p.padCtrl().Get() & rp.PADS_BANK0_GPIO0_DRIVE_Msk) >> rp.PADS_BANK0_GPIO0_DRIVE_Pos
+	var polls [len(mA)]int // Slowest edge at each strength.
+	state := interrupt.Disable()
+	for drive := range polls {
+		p.setDrive(uint32(drive))
+		p.Low()
+		pinPollLevel(feedback, false, maxPolls)
+		for i := 0; i < 8; i++ {
+			p.High()
+			rise := pinPollLevel(feedback, true, maxPolls)
+			p.Low()
+			fall := pinPollLevel(feedback, false, maxPolls)
+			if rise > polls[drive] {
+				polls[drive] = rise
+			}
+			if fall > polls[drive] {
+				polls[drive] = fall
+			}
+		}
+	}
+	p.Set(level)
+	interrupt.Restore(state)
+
+	strongest := polls[len(polls)-1]
+	if strongest == maxPolls {
+		p.setDrive(prevDrive)
+		return 0
+	}
+	drive := 0
+	for polls[drive] > strongest {
+		drive++
+	}
+	p.setDrive(uint32(drive))
+	return mA[drive]
+}
+
+// pinPollLevel returns the number of times p was read before it read level,
+// at most max.
+//
+//go:noinline
+func pinPollLevel(p Pin, level bool, max int) int {
+	n := 0
+	for n < max && p.Get() != level {
+		n++
+	}
+	return n
+}
+
+// setOutputInverted inverts the output driven by the peripheral selected with
+// setFunc. It must be called after setFunc.
+func (p Pin) setOutputInverted(invert bool) {
+	p.ioCtrl().ReplaceBits(boolToBit(invert)*rp.IO_BANK0_GPIO0_CTRL_OUTOVER_INVERT<<rp.IO_BANK0_GPIO0_CTRL_OUTOVER_Pos,
+		rp.IO_BANK0_GPIO0_CTRL_OUTOVER_Msk, 0)
+}
+
+// getFunc returns the function the pin is connected to.
+func (p Pin) getFunc() pinFunc {
+	return pinFunc((p.ioCtrl().Get() & rp.IO_BANK0_GPIO0_CTRL_FUNCSEL_Msk) >> rp.IO_BANK0_GPIO0_CTRL_FUNCSEL_Pos)
+}
+
+// ConfigurePWMInverted connects the pin to its PWM channel like Configure
+// with PinPWM, and inverts the output at the pad. The duty cycle set on the
+// channel is then the fraction of time the pin is low, as needed to drive
+// common-anode LEDs, where a duty cycle of 0 turns the LED off.
+//
+// Unlike the inversion of PWM SetInverting, which applies to the channel, this
+// only affects this pin. Configuring the pin again removes the inversion.
+func (p Pin) ConfigurePWMInverted() {
+	p.Configure(PinConfig{Mode: PinPWM})
+	p.setOutputInverted(true)
+}
+
+// SetIsolation disconnects the pad of the pin from the chip, to cut leakage
+// during long dormant sleeps, or connects it back. The RP2040 has no pad
+// isolation latch like later chips, so this disables the input buffer and the
+// output driver of the pad, which removes the leakage paths of a floating or
+// unused pin. Pull-ups and pull-downs are kept, so the pin stays at a defined
+// level.
+//
+// An isolated pin reads as low and can't wake the chip from SleepUntil.
+func (p Pin) SetIsolation(isolated bool) {
+	if p == NoPin || p >= _NUMBANK0_GPIOS {
+		return
+	}
+	if isolated {
+		p.padCtrl().ReplaceBits(rp.PADS_BANK0_GPIO0_OD,
+			rp.PADS_BANK0_GPIO0_IE_Msk|rp.PADS_BANK0_GPIO0_OD_Msk, 0)
+	} else {
+		p.padCtrl().ReplaceBits(rp.PADS_BANK0_GPIO0_IE,
+			rp.PADS_BANK0_GPIO0_IE_Msk|rp.PADS_BANK0_GPIO0_OD_Msk, 0)
+	}
+}
+
 // setFunc will set pin function to fn.
 func (p Pin) setFunc(fn pinFunc) {
 	// Set input enable, Clear output disable
@@ -173,8 +396,12 @@ func (p Pin) init() {
 }
 
 // Configure configures the gpio pin as per mode.
+//
+// Nothing is written if the pin is already configured as requested, so a
+// library defensively configuring a pin it already owns doesn't glitch it: an
+// output keeps the level it drives instead of going low.
 func (p Pin) Configure(config PinConfig) {
-	if p == NoPin {
+	if p == NoPin || p.isConfigured(config.Mode) {
 		return
 	}
 	p.init()
@@ -192,6 +419,9 @@ func (p Pin) Configure(config PinConfig) {
 	case PinInputPullup:
 		p.setFunc(fnSIO)
 		p.pullup()
+	case PinInputBusKeeper:
+		p.setFunc(fnSIO)
+		p.pullboth()
 	case PinAnalog:
 		p.setFunc(fnNULL)
 		p.pulloff()
@@ -214,6 +444,107 @@ func (p Pin) Configure(config PinConfig) {
 	}
 }
 
+// isConfigured reports whether Configure with mode would leave the pin as it
+// already is, apart from the output level of SIO pins.
+func (p Pin) isConfigured(mode PinMode) bool {
+	const (
+		pulls = rp.PADS_BANK0_GPIO0_PUE | rp.PADS_BANK0_GPIO0_PDE
+		// Set by setFunc.
+		enables = rp.PADS_BANK0_GPIO0_IE | rp.PADS_BANK0_GPIO0_OD
+	)
+	var fn pinFunc
+	padMask := uint32(enables)
+	padWant := uint32(rp.PADS_BANK0_GPIO0_IE)
+	sioOE := -1 // Direction of SIO pins, -1 for peripheral functions.
+	switch mode {
+	case PinOutput:
+		fn, sioOE = fnSIO, 1
+	case PinInput:
+		fn, sioOE = fnSIO, 0
+		padMask |= pulls
+	case PinInputPulldown:
+		fn, sioOE = fnSIO, 0
+		padMask |= pulls
+		padWant |= rp.PADS_BANK0_GPIO0_PDE
+	case PinInputPullup:
+		fn, sioOE = fnSIO, 0
+		padMask |= pulls
+		padWant |= rp.PADS_BANK0_GPIO0_PUE
+	case PinInputBusKeeper:
+		fn, sioOE = fnSIO, 0
+		padMask |= pulls
+		padWant |= pulls
+	case PinAnalog:
+		fn = fnNULL
+		padMask |= pulls
+	case PinUART:
+		fn = fnUART
+	case PinPWM:
+		fn = fnPWM
+	case PinI2C:
+		fn = fnI2C
+		padMask |= pulls | rp.PADS_BANK0_GPIO0_SCHMITT | rp.PADS_BANK0_GPIO0_SLEWFAST
+		padWant |= rp.PADS_BANK0_GPIO0_PUE | rp.PADS_BANK0_GPIO0_SCHMITT
+	case PinSPI:
+		fn = fnSPI
+	case PinPIO0:
+		fn = fnPIO0
+	case PinPIO1:
+		fn = fnPIO1
+	default:
+		return false
+	}
+	// setFunc clears all overrides, so any other field set means the pin
+	// needs configuring, e.g. after ConfigurePWMInverted.
+	if p.ioCtrl().Get() != uint32(fn)<<rp.IO_BANK0_GPIO0_CTRL_FUNCSEL_Pos ||
+		p.padCtrl().Get()&padMask != padWant {
+		return false
+	}
+	return sioOE < 0 || rp.SIO.GPIO_OE.HasBits(1<<p) == (sioOE == 1)
+}
+
+var errPinFunction = errors.New("machine: pin mode not supported by pin")
+
+// SetFunction connects the pin to the peripheral used by mode without
+// reconfiguring it, so pulls, drive strength and other pad settings are kept.
+// This lets libraries repurpose a pin at runtime, for example from a GPIO
+// output to PWM and back. The input modes and PinOutput select the SIO
+// function; pin direction and pulls are not changed.
+//
+// All RP2040 GPIOs can be connected to each of the peripherals, only
+// PinAnalog is restricted to the ADC pins 26 to 29.
+func (p Pin) SetFunction(mode PinMode) error {
+	if p >= _NUMBANK0_GPIOS {
+		return errPinFunction
+	}
+	var fn pinFunc
+	switch mode {
+	case PinOutput, PinInput, PinInputPulldown, PinInputPullup, PinInputBusKeeper:
+		fn = fnSIO
+	case PinAnalog:
+		if p < ADC0 {
+			return errPinFunction
+		}
+		fn = fnNULL
+	case PinUART:
+		fn = fnUART
+	case PinPWM:
+		fn = fnPWM
+	case PinI2C:
+		fn = fnI2C
+	case PinSPI:
+		fn = fnSPI
+	case PinPIO0:
+		fn = fnPIO0
+	case PinPIO1:
+		fn = fnPIO1
+	default:
+		return errPinFunction
+	}
+	p.setFunc(fn)
+	return nil
+}
+
 // Set drives the pin high if value is true else drives it low.
 func (p Pin) Set(value bool) {
 	if p == NoPin {
@@ -231,6 +562,82 @@ func (p Pin) Get() bool {
 	return p.get()
 }
 
+// GetOutput returns the value the pin is set to drive, as last set with Set,
+// High or Low. Unlike Get it does not read the pad, so comparing both on an
+// output pin tells whether something external is holding the line, such as a
+// short to ground or to another output.
+func (p Pin) GetOutput() bool {
+	return rp.SIO.GPIO_OUT.HasBits(1 << p)
+}
+
+// IsReserved reports whether the board wires the pin to one of its own
+// functions, such as power supply control or sensing, so that configuring it
+// as a regular GPIO may disrupt the board. The pin can still be configured,
+// for example to read VBUS or to disable power save on the Raspberry Pi Pico.
+//
+// The onboard LED, available as LED, is not reserved. Only the Raspberry Pi
+// Pico reports reserved pins for now; on other boards check the schematic.
+func (p Pin) IsReserved() bool {
+	return p < _NUMBANK0_GPIOS && reservedPins&(1<<p) != 0
+}
+
+// ErrPinTimeout is returned by WaitFor when the pin did not reach the level in
+// time.
+var ErrPinTimeout = errors.New("machine: timeout waiting for pin level")
+
+// WaitFor busy-waits until the pin reads level, as needed for the busy or
+// ready lines of displays and memory cards, and returns ErrPinTimeout if it
+// doesn't within timeout. Other goroutines don't run during the wait, but
+// interrupts are still handled.
+func (p Pin) WaitFor(level bool, timeout time.Duration) error {
+	if timeout < 0 {
+		timeout = 0
+	}
+	deadline := ticks() + uint64(timeout/time.Microsecond)
+	for p.Get() != level {
+		if ticks() >= deadline {
+			return ErrPinTimeout
+		}
+	}
+	return nil
+}
+
+// Blink generations by pin: changing it stops the goroutine blinking the pin.
+var pinBlinkGen [_NUMBANK0_GPIOS]uint32
+
+// Blink configures the pin as an output and toggles it every period/2 from a
+// goroutine, so the pin completes a cycle each period, until Blink is called
+// again. A period of zero or less stops blinking and drives the pin low.
+// Blink returns immediately.
+//
+// It is mostly meant for the onboard LED, machine.LED on boards that have a
+// single color LED. The GPIO it is on varies from board to board, for example
+// GPIO25 on the Raspberry Pi Pico and GPIO13 on the Adafruit Feather RP2040.
+//
+// Toggling relies on the scheduler, so the timing jitters by however long
+// other goroutines run without yielding.
+func (p Pin) Blink(period time.Duration) {
+	if p >= _NUMBANK0_GPIOS {
+		return
+	}
+	pinBlinkGen[p]++
+	gen := pinBlinkGen[p]
+	p.Configure(PinConfig{Mode: PinOutput})
+	p.Low()
+	if period <= 0 {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(period / 2)
+			if pinBlinkGen[p] != gen {
+				return
+			}
+			p.Set(!p.GetOutput())
+		}
+	}()
+}
+
 // PinChange represents one or more trigger events that can happen on a given GPIO pin
 // on the RP2040. ORed PinChanges are valid input to most IRQ functions.
 type PinChange uint8
@@ -249,20 +656,43 @@ var (
 	setInt       [2][_NUMBANK0_GPIOS]bool
 )
 
+// Missed edge detection of pins configured with SetInterrupt: the level read
+// when their edges were last acknowledged, and the number of missed edges.
+var (
+	pinEdgeLevel   [_NUMBANK0_GPIOS]bool
+	pinMissedEdges [_NUMBANK0_GPIOS]uint32
+)
+
 // SetInterrupt sets an interrupt to be executed when a particular pin changes
 // state. The pin should already be configured as an input, including a pull up
 // or down if no external pull is provided.
 //
-// This call will replace a previously set callback on this pin. You can pass a
-// nil func to unset the pin change interrupt. If you do so, the change
+// If the pin already has a callback on the calling core, it is left in place
+// and ErrNoPinChangeChannel is returned, so libraries sharing pins can detect
+// the conflict instead of silently taking over the pin. To replace a
+// callback, unset it first by passing a nil func, in which case the change
 // parameter is ignored and can be set to any value (such as 0).
+//
+// Each core has its own pin interrupt enables and callbacks: the interrupt is
+// serviced by the core that called SetInterrupt, and must be unset from that
+// same core. Callbacks may be set from both cores concurrently.
 func (p Pin) SetInterrupt(change PinChange, callback func(Pin)) error {
 	if p == NoPin {
 		return nil
 	}
-	if p > 31 || p < 0 {
+	if p >= _NUMBANK0_GPIOS || p < 0 {
 		return ErrInvalidInputPin
 	}
+	state := irqLock()
+	err := p.setInterruptLocked(change, callback)
+	irqUnlock(state)
+	return err
+}
+
+// setInterruptLocked implements SetInterrupt. It must be called with the
+// lock taken by irqLock held, so the interrupt handler of this core does not
+// see a partially written callback.
+func (p Pin) setInterruptLocked(change PinChange, callback func(Pin)) error {
 	core := CurrentCore()
 	if callback == nil {
 		// disable current interrupt
@@ -275,6 +705,9 @@ func (p Pin) SetInterrupt(change PinChange, callback func(Pin)) error {
 		// Callback already configured. Should disable callback by passing a nil callback first.
 		return ErrNoPinChangeChannel
 	}
+	pinMissedEdges[p] = 0
+	pinEdgeLevel[p] = p.Get()
+	p.acknowledgeInterrupt(PinRising | PinFalling)
 	p.setInterrupt(change, true)
 	pinCallbacks[core][p] = callback
 
@@ -287,33 +720,139 @@ func (p Pin) SetInterrupt(change PinChange, callback func(Pin)) error {
 	return nil
 }
 
+// SetInterruptMask sets callback as the pin change interrupt of every pin in
+// mask, where bit n selects GPIO n. The callback receives the pin that
+// triggered it, which makes it convenient for keypads and encoder banks. As
+// with SetInterrupt, a nil callback disables the interrupts of the pins.
+//
+// No pin is changed if mask contains pins above GPIO29 or if one of the pins
+// already has a callback set.
+func SetInterruptMask(mask uint32, change PinChange, callback func(Pin)) error {
+	if mask>>_NUMBANK0_GPIOS != 0 {
+		return ErrInvalidInputPin
+	}
+	state := irqLock()
+	defer irqUnlock(state)
+	if callback != nil {
+		core := CurrentCore()
+		for p := Pin(0); p < _NUMBANK0_GPIOS; p++ {
+			if mask&(1<<p) != 0 && pinCallbacks[core][p] != nil {
+				return ErrNoPinChangeChannel
+			}
+		}
+	}
+	for p := Pin(0); p < _NUMBANK0_GPIOS; p++ {
+		if mask&(1<<p) != 0 {
+			p.setInterruptLocked(change, callback)
+		}
+	}
+	return nil
+}
+
 // gpioHandleInterrupt finds the corresponding pin for the interrupt.
 // C SDK equivalent of gpio_irq_handler
+//
+// Every pin with a pending event is acknowledged and has its callback called
+// before returning, so simultaneous edges on several pins are all serviced
+// by a single interrupt. Edges latched while callbacks run keep the interrupt
+// pending and are serviced on the next invocation.
 func gpioHandleInterrupt(intr interrupt.Interrupt) {
 
 	core := CurrentCore()
-	var gpio Pin
-	for gpio = 0; gpio < _NUMBANK0_GPIOS; gpio++ {
-		var base *irqCtrl
-		switch core {
-		case 0:
-			base = &ioBank0.proc0IRQctrl
-		case 1:
-			base = &ioBank0.proc1IRQctrl
+	var base *irqCtrl
+	switch core {
+	case 0:
+		base = &ioBank0.proc0IRQctrl
+	case 1:
+		base = &ioBank0.proc1IRQctrl
+	}
+	var status [4]uint32
+	for i := range status {
+		status[i] = base.intS[i].Get()
+	}
+	for {
+		pin, events, ok := rp2040gpio.NextEvent(&status)
+		if !ok {
+			break
 		}
-
-		statreg := base.intS[gpio>>3].Get()
-		change := getIntChange(gpio, statreg)
-		if change != 0 {
-			gpio.acknowledgeInterrupt(change)
-			callback := pinCallbacks[core][gpio]
-			if callback != nil {
-				callback(gpio)
-			}
+		gpio := Pin(pin)
+		if gpio >= _NUMBANK0_GPIOS {
+			continue
 		}
+		gpio.acknowledgeEdges(PinChange(events), getIntChange(gpio, base.intE[gpio>>3].Get()))
+		callback := pinCallbacks[core][gpio]
+		if callback != nil {
+			callback(gpio)
+		}
+	}
+}
+
+// acknowledgeEdges acknowledges the events in change of a pin being serviced
+// along with both its edge events, and adds the edges missed since the last
+// acknowledgement to pinMissedEdges, given the events enabled.
+//
+// The raw status in INTR latches every edge, enabled or not, but only once per
+// kind. Edges alternate, so the number of edges since the last
+// acknowledgement is at least the number of edge kinds latched, and is odd if
+// and only if the level changed. The smallest such number gives the enabled
+// edges that happened at least; those beyond one per enabled kind latched were
+// missed.
+func (p Pin) acknowledgeEdges(change, enabled PinChange) {
+	latched := getIntChange(p, ioBank0.intR[p>>3].Get())
+	level := p.Get()
+	p.acknowledgeInterrupt(change | PinRising | PinFalling)
+	prev := pinEdgeLevel[p]
+	pinEdgeLevel[p] = level
+
+	rising := latched&PinRising != 0
+	falling := latched&PinFalling != 0
+	n := boolToBit(rising) + boolToBit(falling)
+	if n == 0 {
+		return // Level event, edges are not tracked.
+	}
+	if n&1 != boolToBit(level != prev) {
+		n++
+	}
+	// The first edge leaves the previous level.
+	rises := n / 2
+	if !prev {
+		rises = (n + 1) / 2
+	}
+	var happened, serviced uint32
+	if enabled&PinRising != 0 {
+		happened += rises
+		serviced += boolToBit(rising)
+	}
+	if enabled&PinFalling != 0 {
+		happened += n - rises
+		serviced += boolToBit(falling)
+	}
+	if happened > serviced {
+		pinMissedEdges[p] += happened - serviced
 	}
 }
 
+// MissedEdges returns how many edges the pin change interrupt set with
+// SetInterrupt missed since it was set. The count wraps around.
+//
+// An edge is missed when another edge of the same kind arrives before the
+// first is serviced, for example while a callback runs or while interrupts
+// are disabled: the hardware latches one event per kind, so the callback is
+// called once for both. With PinRising|PinFalling, a rising and a falling
+// edge serviced by the same call are not counted as missed.
+//
+// Missed edges are inferred from the raw interrupt status, which latches
+// edges of both kinds whether enabled or not, and from the pin level, since
+// edges alternate. The count is thus a lower bound: extra edges that leave the
+// pin at the level it would have had without them, such as a short pulse
+// while a callback runs, cannot be told apart from none.
+func (p Pin) MissedEdges() uint32 {
+	if p >= _NUMBANK0_GPIOS {
+		return 0
+	}
+	return pinMissedEdges[p]
+}
+
 // events returns the bit representation of the pin change for the rp2040.
 func (change PinChange) events() uint32 {
 	return uint32(change)
@@ -329,3 +868,66 @@ func (p Pin) ioIntBit(change PinChange) uint32 {
 func getIntChange(p Pin, status uint32) PinChange {
 	return PinChange(status>>(4*(p%8))) & 0xf
 }
+
+// InterruptEnabled returns the events enabled as interrupts for the pin on the
+// calling core, to check what SetInterrupt actually programmed. The bits are
+// those of PinChange: PinFalling and PinRising for edges, plus bit 0 for a
+// low level and bit 1 for a high level, which SetInterrupt doesn't use.
+func (p Pin) InterruptEnabled() uint32 {
+	if p >= _NUMBANK0_GPIOS {
+		return 0
+	}
+	return uint32(getIntChange(p, coreIRQCtrl().intE[p>>3].Get()))
+}
+
+// SleepUntil puts the chip into dormant mode, its lowest power state, and
+// returns once change occurs on the pin. The pin should already be configured
+// as an input, including a pull up or down if no external pull is provided.
+//
+// Before going dormant all clocks are switched over to the crystal oscillator
+// and the PLLs are powered down. On wake the regular clock configuration is
+// restored before SleepUntil returns. Peripherals clocked from clkUSB and
+// clkADC (USB and the ADC) do not work while dormant and USB connections are
+// usually lost, and the system timer does not advance while the chip sleeps.
+// If the RTC runs from an external clock, see SetRTCClockInput, the time slept
+// is measured with it and accounted for by TimeSinceBoot.
+//
+// Wake latency is dominated by the crystal oscillator startup delay and the
+// PLLs locking again, around a millisecond in total.
+//
+// Interrupts are held off until the clocks are restored. Pin change callbacks
+// set with SetInterrupt stay registered across the sleep: if the pin has one
+// on the calling core, it is called for the edge that woke the chip right
+// before SleepUntil returns, as are callbacks for edges on other pins.
+func (p Pin) SleepUntil(change PinChange) {
+	if p == NoPin {
+		return
+	}
+	state := interrupt.Disable()
+	clocks.runFromXOSC()
+
+	rtcRunning := rtcClockInputFreq != 0 && RTC.isActive()
+	var rtcBefore int64
+	if rtcRunning {
+		rtcBefore = RTC.seconds()
+	}
+
+	p.ctrlSetInterrupt(change, true, &ioBank0.dormantWakeIRQctrl)
+	xosc.goDormant()
+	if rtcRunning {
+		if slept := RTC.seconds() - rtcBefore; slept > 0 {
+			dormantMicros += uint64(slept) * 1e6
+		}
+	}
+	if pinCallbacks[CurrentCore()][p] != nil {
+		// Keep the latched edge so the regular GPIO interrupt, pending
+		// since the wake, services it.
+		ioBank0.dormantWakeIRQctrl.intE[p>>3].ClearBits(p.ioIntBit(change))
+	} else {
+		// Disabling also clears the latched edge that woke the chip.
+		p.ctrlSetInterrupt(change, false, &ioBank0.dormantWakeIRQctrl)
+	}
+
+	clocks.init()
+	interrupt.Restore(state)
+}
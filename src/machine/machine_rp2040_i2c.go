@@ -6,17 +6,28 @@ import (
 	"device/rp"
 	"errors"
 	"internal/itoa"
+	"runtime/interrupt"
+	"runtime/volatile"
+	"time"
+	"unsafe"
 )
 
-// I2C on the RP2040.
+// I2C on the RP2040. I2C0 and I2C1 are the only instances of the two I2C
+// peripherals, drivers should share them instead of declaring their own.
+//
+// When Configure is called without pins, I2C0 uses the board's I2C0_SDA_PIN
+// and I2C0_SCL_PIN, and I2C1 uses I2C1_SDA_PIN and I2C1_SCL_PIN. On the
+// Raspberry Pi Pico these are GP4/GP5 and GP2/GP3 respectively.
 var (
 	I2C0  = &_I2C0
 	_I2C0 = I2C{
 		Bus: rp.I2C0,
+		scl: NoPin,
 	}
 	I2C1  = &_I2C1
 	_I2C1 = I2C{
 		Bus: rp.I2C1,
+		scl: NoPin,
 	}
 )
 
@@ -49,12 +60,105 @@ type I2CConfig struct {
 	// which pins match the desired bus.
 	SDA, SCL Pin
 	Mode     I2CMode
+	// SDAHoldCount overrides the SDA hold time, in clk_sys cycles, computed
+	// from the frequency. Useful for targets that need a longer setup time on
+	// capacitive buses. A value of 0 selects the hold time automatically.
+	SDAHoldCount uint32
+	// UseInterrupts selects the interrupt driven backend for controller
+	// transfers made with Tx. Instead of polling the peripheral, the transfer
+	// is advanced from the I2C interrupt and Tx waits for it to complete,
+	// leaving the CPU free for other goroutines. See also StartTx.
+	UseInterrupts bool
+	// DisableIRQDuringTx disables interrupts on the calling core for the
+	// whole of each Tx call, so that no interrupt handler can stretch the bus
+	// timing mid-transfer. Other interrupts are delayed by up to the
+	// transfer duration, about 100us per byte at 100kHz, and other goroutines
+	// don't run during the transfer. Takes precedence over UseInterrupts.
+	DisableIRQDuringTx bool
+	// InterByteDelay is a pause inserted between the bytes of a transfer, for
+	// targets which can't keep up even with clock stretching. The controller
+	// holds SCL low during the pause. This reduces throughput a lot, a 10us
+	// delay roughly halves it at 100kHz. Zero, the default, inserts no delay.
+	// Transfers with a delay always use the polled backend.
+	InterByteDelay time.Duration
+	// SettleDelay is how long Configure waits after connecting the pins to
+	// the peripheral, for the pull-ups to bring the lines high so the first
+	// transfer does not fail. Zero selects 50us, enough for 4.7k pull-ups on
+	// a heavily loaded bus. A negative value disables the wait.
+	SettleDelay time.Duration
+	// Retries is the number of times Tx retries a transfer which failed
+	// because of a transient bus condition: arbitration lost to another
+	// controller, or an abort with no reason recorded. Other errors, such as
+	// a target not acknowledging, are returned right away. Retried transfers
+	// are repeated from the start, including the write.
+	Retries uint8
+	// PostAbortDelay is how long the bus is left idle after a transfer
+	// aborted, for example because the target did not acknowledge, before
+	// the next transfer starts. It helps slow targets which need time to
+	// recover from an aborted transfer. The delay only applies after an
+	// abort, it is measured from when the abort was detected and is waited
+	// at the start of the next transfer, including retries, so it costs
+	// nothing if the next transfer comes later anyway. Zero, the default,
+	// adds no delay.
+	PostAbortDelay time.Duration
 }
 
+// Maximum I2C frequency supported by the RP2040, fast mode plus.
+const i2cMaxBaudRate = 1_000_000
+
 type I2C struct {
-	Bus          *rp.I2C0_Type
+	Bus *rp.I2C0_Type
+
+	// Tracing hooks called, when set, during controller transfers made with
+	// Tx, Transaction and ReadByteAck, for instance to log the traffic with a
+	// device while working out its protocol. OnStart is called with the target
+	// address before the transfer begins, OnByte after each byte written or
+	// read, and then either OnStop when the transfer completed or OnAbort
+	// with the error it failed with. Transfers on the interrupt driven
+	// backend are not traced.
+	//
+	// The hooks cost a nil check per byte when unset. When set, they run on
+	// the calling goroutine between bytes, with interrupts disabled if
+	// DisableIRQDuringTx is set. The controller holds SCL low meanwhile, so
+	// slow hooks, such as ones printing each byte, slow the transfer down
+	// and count toward its 40ms timeout; buffer the events and print them
+	// afterwards for long transfers.
+	OnStart func(addr uint16)
+	OnByte  func(b byte, read bool)
+	OnStop  func()
+	OnAbort func(err error)
+
 	mode         I2CMode
 	txInProgress bool
+	// sdaHoldCount is the user configured SDA hold time. 0 means automatic.
+	sdaHoldCount uint32
+	// baudRate is the frequency last set with SetBaudRate.
+	baudRate uint32
+	// restartOnNext is set if the next byte read with ReadByteAck starts a
+	// new transfer.
+	restartOnNext bool
+	// inRead is set while a transfer begun with StartRead is open, until it
+	// ends with a STOP, an abort or a timeout.
+	inRead bool
+	// useInterrupts is set if Tx uses the interrupt driven backend.
+	useInterrupts bool
+	// disableIRQ is set if transfers run with interrupts disabled.
+	disableIRQ bool
+	// interByteDelay is the delay between bytes in microseconds.
+	interByteDelay uint64
+	// retries is the number of times Tx retries transient failures.
+	retries uint8
+	// postAbortDelay is the idle time after an abort in microseconds.
+	postAbortDelay uint64
+	// abortTicks is the time of the last abort, see postAbortWait.
+	abortTicks uint64
+	// lastAbort is the reason of the last abort, see LastAbort.
+	lastAbort I2CAbortError
+	// scl is the clock pin set with Configure, sampled to detect a bus held
+	// by another device before starting a transfer.
+	scl Pin
+	// xfer is the state of the transfer in progress on the interrupt driven backend.
+	xfer i2cTransfer
 }
 
 var (
@@ -67,8 +171,55 @@ var (
 	ErrI2CAlreadyListening = errors.New("i2c already listening")
 	ErrI2CWrongMode        = errors.New("i2c wrong mode")
 	ErrI2CUnderflow        = errors.New("i2c underflow")
+	ErrInvalidI2CSDAHold   = errors.New("invalid i2c sda hold count")
+	ErrI2CBusy             = errors.New("i2c transfer in progress")
+	errBusBusy             = errors.New("i2c bus busy: SCL held low by another device")
+	errI2CRXOverflow       = errors.New("i2c rx fifo overflow, received data lost")
+	errI2CSelfTestReset    = errors.New("i2c self test: registers not at reset values after reset")
+	errI2CSelfTestRegister = errors.New("i2c self test: register write not read back")
+	errI2CSelfTestFIFO     = errors.New("i2c self test: unexpected fifo depth")
+	errI2CEmptyOp          = errors.New("i2c transaction op with empty buffer")
+	errSMBusBlockLength    = errors.New("smbus block length is zero or larger than buffer")
+	errInvalidI2CTiming    = errors.New("invalid i2c timing: count out of range")
+	errI2CBusStuck         = errors.New("i2c bus stuck: SDA or SCL still low after recovery")
+	errI2CBusActive        = errors.New("i2c bus busy: another controller did not release it in time")
+	errI2CNoRead           = errors.New("i2c no read transfer open, call StartRead first")
+
+	errI2CAddrNot7Bit  error = i2cAddrError("i2c target address above 0x7f")
+	errI2CAddrShifted  error = i2cAddrError("i2c target address above 0x7f looks like a shifted 8-bit address, convert it with Addr7")
+	errI2CAddrReserved error = i2cAddrError("i2c target address reserved (0x00-0x07, 0x78-0x7f)")
 )
 
+// i2cAddrError is a more descriptive variant of ErrInvalidTgtAddr, which it
+// matches with errors.Is.
+type i2cAddrError string
+
+func (e i2cAddrError) Error() string { return string(e) }
+
+// Is reports whether target is ErrInvalidTgtAddr, for use with errors.Is.
+func (e i2cAddrError) Is(target error) bool { return target == ErrInvalidTgtAddr }
+
+// Addr7 returns the 7-bit address, as used by this package, of a target whose
+// address is given in the shifted 8-bit form found in some datasheets, where
+// the address occupies the upper 7 bits and the lowest bit selects read or
+// write. For example the 8-bit write address 0xD0 is the 7-bit address 0x68.
+func Addr7(shifted8 uint8) uint16 {
+	return uint16(shifted8 >> 1)
+}
+
+// checkTgtAddr returns an error if addr is not a valid 7-bit target address.
+func checkTgtAddr(addr uint16) error {
+	switch {
+	case addr >= 0x80 && addr <= 0xff && addr&1 == 0:
+		return errI2CAddrShifted
+	case addr >= 0x80:
+		return errI2CAddrNot7Bit
+	case isReservedI2CAddr(uint8(addr)):
+		return errI2CAddrReserved
+	}
+	return nil
+}
+
 // Tx performs a write and then a read transfer placing the result in
 // in r.
 //
@@ -82,16 +233,590 @@ var (
 //	i2c.Tx(addr, w, nil)
 //
 // Performs only a write transfer.
+//
+//	i2c.Tx(addr, nil, nil)
+//
+// Checks that a target acknowledges addr, useful to detect devices on the
+// bus. No data is written to the target.
+//
+// If the target does not acknowledge addr, or the transfer is aborted with no
+// reason reported, the error is ErrI2CGeneric itself. Other aborts return an
+// I2CAbortError, or an I2CDataNackError for a written byte not acknowledged.
+// LastAbort decodes the reason in all cases.
 func (i2c *I2C) Tx(addr uint16, w, r []byte) error {
+	_, err := i2c.TxPartial(addr, w, r)
+	return err
+}
+
+// TxPartial performs a transfer like Tx but also returns the number of bytes
+// read into r. If the transfer is aborted or times out during the read phase
+// the first n bytes of r are still valid, which lets streaming readers salvage
+// partial data.
+func (i2c *I2C) TxPartial(addr uint16, w, r []byte) (n int, err error) {
 	if i2c.mode != I2CModeController {
-		return ErrI2CWrongMode
+		return 0, ErrI2CWrongMode
+	}
+	// Check the address before it is narrowed to 8 bits, which would turn
+	// 10-bit addresses into valid looking 7-bit ones.
+	if err := checkTgtAddr(addr); err != nil {
+		return 0, err
 	}
+	for attempt := 0; ; attempt++ {
+		n, err = i2c.txOnce(addr, w, r)
+		if err == nil || attempt >= int(i2c.retries) || !i2c.isRetryable(err) {
+			return n, err
+		}
+	}
+}
 
+// txOnce performs a single attempt of a Tx transfer on the configured backend.
+func (i2c *I2C) txOnce(addr uint16, w, r []byte) (n int, err error) {
 	// timeout in microseconds.
 	const timeout = 40 * 1000 // 40ms is a reasonable time for a real-time system.
-	return i2c.tx(uint8(addr), w, r, timeout)
+	if i2c.useInterrupts && !i2c.disableIRQ {
+		return i2c.txInterrupt(uint8(addr), w, r, timeout)
+	}
+	i2c.traceStart(addr)
+	if i2c.disableIRQ {
+		state := interrupt.Disable()
+		n, err = i2c.tx(uint8(addr), w, r, timeout)
+		interrupt.Restore(state)
+	} else {
+		n, err = i2c.tx(uint8(addr), w, r, timeout)
+	}
+	i2c.traceEnd(err)
+	return n, err
+}
+
+// TxAtFrequency performs a transfer like Tx with the bus running at hz, and
+// restores the previous frequency afterwards. This lets a single fast device,
+// such as a fast mode plus display, share a bus with slower devices without
+// reconfiguring the bus.
+//
+// hz is validated like in SetBaudRate. The bus is left untouched if it is
+// invalid.
+func (i2c *I2C) TxAtFrequency(addr uint16, w, r []byte, hz uint32) error {
+	if i2c.mode != I2CModeController {
+		return ErrI2CWrongMode
+	}
+	prev := i2c.baudRate
+	if err := i2c.SetBaudRate(hz); err != nil {
+		return err
+	}
+	err := i2c.Tx(addr, w, r)
+	if restoreErr := i2c.SetBaudRate(prev); err == nil {
+		err = restoreErr
+	}
+	return err
+}
+
+// ReadStream reads len(buf) bytes from the target at addr in a single read
+// transfer, without writing a register pointer first. This suits targets which
+// return consecutive data on every read, such as IMU FIFOs. Unlike Tx, the
+// timeout grows with the length of buf so long reads at low bus frequencies
+// do not time out.
+//
+// Consecutive calls are independent transfers, each beginning with a START
+// and ending with a STOP; they do not interact with StartRead.
+func (i2c *I2C) ReadStream(addr uint8, buf []byte) error {
+	if i2c.mode != I2CModeController {
+		return ErrI2CWrongMode
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	baud := i2c.baudRate
+	if baud == 0 {
+		baud = 100_000
+	}
+	// 40ms like Tx plus the time to clock 9 bits per byte, doubled for
+	// clock stretching.
+	timeout := 40*1000 + 2*uint64(len(buf))*9*1_000_000/uint64(baud)
+	if i2c.disableIRQ {
+		state := interrupt.Disable()
+		_, err := i2c.tx(addr, nil, buf, timeout)
+		interrupt.Restore(state)
+		return err
+	}
+	if i2c.useInterrupts {
+		_, err := i2c.txInterrupt(addr, nil, buf, timeout)
+		return err
+	}
+	_, err := i2c.tx(addr, nil, buf, timeout)
+	return err
+}
+
+// ReadRegisterU16 reads the 16-bit unsigned register at register of the
+// device at address, whose bytes are in big-endian order, most significant
+// first, if bigEndian is set, and little-endian otherwise.
+func (i2c *I2C) ReadRegisterU16(address, register uint8, bigEndian bool) (uint16, error) {
+	var buf [2]byte
+	err := i2c.Tx(uint16(address), []byte{register}, buf[:])
+	if err != nil {
+		return 0, err
+	}
+	if bigEndian {
+		return uint16(buf[0])<<8 | uint16(buf[1]), nil
+	}
+	return uint16(buf[1])<<8 | uint16(buf[0]), nil
+}
+
+// ReadRegisterI16 is like ReadRegisterU16 for two's complement signed
+// registers, as used by most IMUs for their measurements.
+func (i2c *I2C) ReadRegisterI16(address, register uint8, bigEndian bool) (int16, error) {
+	v, err := i2c.ReadRegisterU16(address, register, bigEndian)
+	return int16(v), err
+}
+
+// ReadRegisterAfter writes register to the device at address, waits for delay
+// and then reads data, for sensors which need a conversion time before the
+// result can be read, such as the BME280. The write and the read are separate
+// transfers, and other goroutines run during the wait, which is timed with
+// the microsecond timer.
+func (i2c *I2C) ReadRegisterAfter(address, register uint8, data []byte, delay time.Duration) error {
+	err := i2c.Tx(uint16(address), []byte{register}, nil)
+	if err != nil {
+		return err
+	}
+	deadline := ticks() + uint64(delay/time.Microsecond)
+	for ticks() < deadline {
+		gosched()
+	}
+	return i2c.Tx(uint16(address), nil, data)
+}
+
+// StartRead begins a read transfer from addr whose bytes are then read one at
+// a time with ReadByteAck. This lets the length of the read depend on the data,
+// as in SMBus block reads where the first byte gives the number of bytes that
+// follow.
+//
+//	i2c.StartRead(addr)
+//	count, _ := i2c.ReadByteAck(true)
+//	for i := 0; i < int(count); i++ {
+//		buf[i], _ = i2c.ReadByteAck(i < int(count)-1)
+//	}
+func (i2c *I2C) StartRead(addr uint16) error {
+	if i2c.mode != I2CModeController {
+		return ErrI2CWrongMode
+	}
+	if err := checkTgtAddr(addr); err != nil {
+		return err
+	}
+	i2c.traceStart(addr)
+	err := i2c.setTarget(uint8(addr))
+	if err != nil {
+		i2c.traceEnd(err)
+		return err
+	}
+	i2c.restartOnNext = true
+	i2c.inRead = true
+	return nil
+}
+
+// ReadByteAck reads a byte of a transfer begun with StartRead. If ack is true
+// the byte is acknowledged and the target keeps the bus for more bytes;
+// otherwise it is not acknowledged and the transfer ends with a STOP, as
+// required after the last byte of a read. Until then the controller holds
+// SCL low between calls.
+//
+// The transfer is closed once it ended with a STOP, an abort or a timeout;
+// further calls return an error until StartRead is called again. On timeout
+// the transfer is aborted, which releases the bus with a STOP.
+func (i2c *I2C) ReadByteAck(ack bool) (byte, error) {
+	if i2c.mode != I2CModeController {
+		return 0, ErrI2CWrongMode
+	}
+	if !i2c.inRead {
+		return 0, errI2CNoRead
+	}
+	const timeout = 40 * 1000 // See Tx.
+	deadline := ticks() + timeout
+	// The controller NACKs a read command only when it carries a STOP.
+	i2c.Bus.IC_DATA_CMD.Set(
+		boolToBit(i2c.restartOnNext)<<rp.I2C0_IC_DATA_CMD_RESTART_Pos |
+			boolToBit(!ack)<<rp.I2C0_IC_DATA_CMD_STOP_Pos |
+			rp.I2C0_IC_DATA_CMD_CMD)
+	i2c.restartOnNext = false
+	for i2c.readAvailable() == 0 {
+		abortReason := i2c.getAbortReason()
+		if abortReason != 0 {
+			i2c.clearAbortReason()
+			i2c.inRead = false
+			i2c.traceEnd(abortReason)
+			return 0, abortError(abortReason, -1)
+		}
+		if ticks() > deadline {
+			i2c.abortRead()
+			i2c.traceEnd(errI2CReadTimeout)
+			return 0, errI2CReadTimeout
+		}
+		i2c.yield()
+	}
+	b := uint8(i2c.Bus.IC_DATA_CMD.Get())
+	i2c.traceByte(b, true)
+	if !ack {
+		for !i2c.interrupted(rp.I2C0_IC_RAW_INTR_STAT_STOP_DET) {
+			if ticks() > deadline {
+				i2c.abortRead()
+				i2c.traceEnd(errI2CReadTimeout)
+				return b, errI2CReadTimeout
+			}
+			i2c.yield()
+		}
+		i2c.Bus.IC_CLR_STOP_DET.Get()
+		i2c.inRead = false
+		i2c.traceEnd(nil)
+	}
+	return b, nil
+}
+
+// abortRead aborts the StartRead transfer after a timeout, so the controller
+// issues a STOP instead of holding SCL low, and closes it.
+func (i2c *I2C) abortRead() {
+	i2c.Abort()
+	if i2c.interrupted(rp.I2C0_IC_RAW_INTR_STAT_TX_ABRT) {
+		i2c.clearAbortReason()
+	}
+}
+
+// Config returns the configuration currently programmed in the I2C
+// peripheral. Frequency is the actual SCL frequency decoded from the clock
+// counts, which may be below the one requested. SDA and SCL are the pins
+// connected to the bus, NoPin if none is. SDAHoldCount is the override passed
+// to Configure, 0 if the hold time is computed from the frequency, so that
+// passing the result to Configure keeps the bus configured the same way.
+func (i2c *I2C) Config() I2CConfig {
+	config := I2CConfig{
+		SDA:                NoPin,
+		SCL:                NoPin,
+		Mode:               i2c.mode,
+		SDAHoldCount:       i2c.sdaHoldCount,
+		UseInterrupts:      i2c.useInterrupts,
+		DisableIRQDuringTx: i2c.disableIRQ,
+		InterByteDelay:     time.Duration(i2c.interByteDelay) * time.Microsecond,
+		Retries:            i2c.retries,
+		PostAbortDelay:     time.Duration(i2c.postAbortDelay) * time.Microsecond,
+	}
+	config.Frequency = i2c.ActualBaudrate()
+	bus := uint8(0)
+	if i2c.Bus == rp.I2C1 {
+		bus = 1
+	}
+	for p := Pin(0); p < _NUMBANK0_GPIOS; p++ {
+		// I2C pins come in pairs alternating between I2C0 and I2C1.
+		if p.getFunc() != fnI2C || uint8(p>>1)&1 != bus {
+			continue
+		}
+		if p%2 == 0 {
+			config.SDA = p
+		} else {
+			config.SCL = p
+		}
+	}
+	return config
+}
+
+// I2COp is a write or read step of a Transaction.
+type I2COp struct {
+	// Buf holds the bytes to write, or receives the bytes read.
+	Buf []byte
+	// Read selects a read of len(Buf) bytes instead of a write.
+	Read bool
+	// NoStop joins the op to the next one with a repeated START instead of
+	// ending it with a STOP. It is ignored on the last op, which always ends
+	// with a STOP.
+	NoStop bool
+}
+
+// Transaction performs ops in order on the target at addr. Ops joined with
+// NoStop are sent with repeated STARTs so no other controller can take the
+// bus between them, which makes the intended bus behavior of long command
+// sequences explicit:
+//
+//	i2c.Transaction(addr, []machine.I2COp{
+//		{Buf: []byte{reg}, NoStop: true},
+//		{Buf: data, Read: true},
+//	})
+//
+// Ops must not be empty. The transaction stops at the first op which fails.
+func (i2c *I2C) Transaction(addr uint16, ops []I2COp) error {
+	if i2c.mode != I2CModeController {
+		return ErrI2CWrongMode
+	}
+	if err := checkTgtAddr(addr); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if len(op.Buf) == 0 {
+			return errI2CEmptyOp
+		}
+	}
+	if i2c.xfer.busy.Get() != 0 {
+		return ErrI2CBusy
+	}
+	if i2c.disableIRQ {
+		state := interrupt.Disable()
+		defer interrupt.Restore(state)
+	}
+	i2c.traceStart(addr)
+	err := i2c.setTarget(uint8(addr))
+	for i := 0; err == nil && i < len(ops); i++ {
+		op := ops[i]
+		restart := i == 0 || ops[i-1].NoStop
+		stop := !op.NoStop || i == len(ops)-1
+		err = i2c.txOp(op, restart, stop)
+	}
+	i2c.traceEnd(err)
+	return err
+}
+
+// SMBusBlockRead performs an SMBus block read, as used by battery gauges and
+// PMBus devices: it writes cmd, then reads a length byte followed by that many
+// data bytes into buf, all in one transaction. It returns the number of bytes
+// read.
+//
+// If the target announces zero bytes or more than len(buf), one more byte is
+// read to end the transaction and an error is returned. SMBus
+// limits blocks to 32 bytes, PMBus to 255. Packet error checking is not
+// supported.
+func (i2c *I2C) SMBusBlockRead(addr, cmd uint8, buf []byte) (int, error) {
+	if i2c.mode != I2CModeController {
+		return 0, ErrI2CWrongMode
+	}
+	if err := checkTgtAddr(uint16(addr)); err != nil {
+		return 0, err
+	}
+	if i2c.xfer.busy.Get() != 0 {
+		return 0, ErrI2CBusy
+	}
+	if i2c.disableIRQ {
+		state := interrupt.Disable()
+		defer interrupt.Restore(state)
+	}
+	i2c.traceStart(uint16(addr))
+	var b [1]byte
+	n := 0
+	err := i2c.setTarget(addr)
+	if err == nil {
+		b[0] = cmd
+		err = i2c.txOp(I2COp{Buf: b[:]}, true, false)
+	}
+	if err == nil {
+		err = i2c.txOp(I2COp{Buf: b[:], Read: true}, true, false)
+	}
+	if err == nil {
+		n = int(b[0])
+		if n == 0 || n > len(buf) {
+			// NACK a byte to end the transaction.
+			n = 0
+			err = i2c.txOp(I2COp{Buf: b[:], Read: true}, false, true)
+			if err == nil {
+				err = errSMBusBlockLength
+			}
+		} else {
+			err = i2c.txOp(I2COp{Buf: buf[:n], Read: true}, false, true)
+			if err != nil {
+				n = 0
+			}
+		}
+	}
+	i2c.traceEnd(err)
+	return n, err
+}
+
+// txOp performs an op of a Transaction. The first byte is sent with a
+// RESTART if restart is set and the last one with a STOP if stop is set.
+func (i2c *I2C) txOp(op I2COp, restart, stop bool) error {
+	const timeout = 40 * 1000 // See Tx, applies to each op.
+	deadline := ticks() + timeout
+	for j := range op.Buf {
+		first := j == 0
+		last := j == len(op.Buf)-1
+		if !first {
+			i2c.interByteWait()
+		}
+		for i2c.writeAvailable() == 0 {
+			if ticks() > deadline {
+				return errI2CWriteTimeout
+			}
+			i2c.yield()
+		}
+		cmd := boolToBit(first && restart)<<rp.I2C0_IC_DATA_CMD_RESTART_Pos |
+			boolToBit(last && stop)<<rp.I2C0_IC_DATA_CMD_STOP_Pos
+		if op.Read {
+			i2c.Bus.IC_DATA_CMD.Set(cmd | rp.I2C0_IC_DATA_CMD_CMD)
+			for i2c.readAvailable() == 0 {
+				if abortReason := i2c.getAbortReason(); abortReason != 0 {
+					i2c.clearAbortReason()
+					i2c.waitStop(deadline)
+					return abortError(abortReason, -1)
+				}
+				if ticks() > deadline {
+					return errI2CReadTimeout
+				}
+				i2c.yield()
+			}
+			op.Buf[j] = uint8(i2c.Bus.IC_DATA_CMD.Get())
+			i2c.traceByte(op.Buf[j], true)
+			continue
+		}
+		i2c.Bus.IC_DATA_CMD.Set(cmd | uint32(op.Buf[j]))
+		// Wait for the byte to leave the shift register, see tx.
+		for !i2c.interrupted(rp.I2C0_IC_RAW_INTR_STAT_TX_EMPTY) {
+			if ticks() > deadline {
+				return errI2CWriteTimeout
+			}
+			i2c.yield()
+		}
+		if abortReason := i2c.getAbortReason(); abortReason != 0 {
+			i2c.clearAbortReason()
+			// The controller issues a STOP after an abort.
+			i2c.waitStop(deadline)
+			return abortError(abortReason, -1)
+		}
+		i2c.traceByte(op.Buf[j], false)
+	}
+	if stop {
+		return i2c.waitStop(deadline)
+	}
+	return nil
+}
+
+// waitStop waits for the STOP condition to be sent and clears it.
+func (i2c *I2C) waitStop(deadline uint64) error {
+	for !i2c.interrupted(rp.I2C0_IC_RAW_INTR_STAT_STOP_DET) {
+		if ticks() > deadline {
+			return errI2CWriteTimeout
+		}
+		i2c.yield()
+	}
+	i2c.Bus.IC_CLR_STOP_DET.Get()
+	return nil
+}
+
+// I2CScanResult is a target found by Scan.
+type I2CScanResult struct {
+	Addr uint8
+	// Collision is set if collision detection was requested and the target
+	// did not read back the same data each time, which hints at several
+	// targets sharing the address.
+	Collision bool
+}
+
+// Number of bytes and times each target is read to detect collisions.
+const (
+	i2cScanReadLen     = 4
+	i2cScanReadRepeats = 4
+)
+
+// Scan probes the non-reserved 7-bit addresses, 0x08 to 0x77, and returns the
+// targets that acknowledged, by ascending address. Each address is probed with
+// an address-only transfer, as done by Tx(addr, nil, nil).
+//
+// With detectCollisions, each target found is also read a few times without
+// selecting a register. Two targets sharing an address both acknowledge it,
+// then drive the data line together, which usually makes reads inconsistent
+// or fail: such targets are reported with Collision set. This is a heuristic
+// meant as a diagnostic. Targets whose data changes between reads, such as
+// sensors streaming samples or counters, are reported as collisions too.
+//
+// Scan stops at the first error other than an address not acknowledged, and
+// returns it along with the targets found so far.
+func (i2c *I2C) Scan(detectCollisions bool) ([]I2CScanResult, error) {
+	var found []I2CScanResult
+	for addr := uint8(0x08); addr < 0x78; addr++ {
+		err := i2c.Tx(uint16(addr), nil, nil)
+		if err == ErrI2CGeneric && i2c.lastAbort&rp.I2C0_IC_TX_ABRT_SOURCE_ABRT_7B_ADDR_NOACK != 0 {
+			continue
+		}
+		if err != nil {
+			return found, err
+		}
+		res := I2CScanResult{Addr: addr}
+		if detectCollisions {
+			res.Collision = i2c.readsDiffer(addr)
+		}
+		found = append(found, res)
+	}
+	return found, nil
+}
+
+// readsDiffer reads from addr a few times and reports whether a read failed or
+// returned different data than the first.
+func (i2c *I2C) readsDiffer(addr uint8) bool {
+	var first, buf [i2cScanReadLen]byte
+	if i2c.Tx(uint16(addr), nil, first[:]) != nil {
+		return true
+	}
+	for i := 1; i < i2cScanReadRepeats; i++ {
+		if i2c.Tx(uint16(addr), nil, buf[:]) != nil || buf != first {
+			return true
+		}
+	}
+	return false
+}
+
+// SelfTest checks that the I2C peripheral is alive, for hardware bring-up.
+// It resets the peripheral and checks the reset value of IC_CON, writes and
+// reads back IC_SAR, and checks the FIFO depths reported by IC_COMP_PARAM_1.
+// The bus pins are not driven.
+//
+// The peripheral is left in reset state, Configure must be called again
+// afterwards.
+func (i2c *I2C) SelfTest() error {
+	i2c.reset()
+	const icConReset = 0x65
+	if i2c.Bus.IC_CON.Get() != icConReset {
+		return errI2CSelfTestReset
+	}
+	for _, pattern := range []uint32{0x2aa, 0x155} {
+		i2c.Bus.IC_SAR.Set(pattern)
+		if i2c.Bus.IC_SAR.Get() != pattern {
+			return errI2CSelfTestRegister
+		}
+	}
+	const fifoDepth = 16
+	param := i2c.Bus.IC_COMP_PARAM_1.Get()
+	txDepth := (param&rp.I2C0_IC_COMP_PARAM_1_TX_BUFFER_DEPTH_Msk)>>rp.I2C0_IC_COMP_PARAM_1_TX_BUFFER_DEPTH_Pos + 1
+	rxDepth := (param&rp.I2C0_IC_COMP_PARAM_1_RX_BUFFER_DEPTH_Msk)>>rp.I2C0_IC_COMP_PARAM_1_RX_BUFFER_DEPTH_Pos + 1
+	if txDepth != fifoDepth || rxDepth != fifoDepth {
+		return errI2CSelfTestFIFO
+	}
+	i2c.reset()
+	return nil
+}
+
+// I2CStatus is the value of the IC_STATUS register of an I2C peripheral.
+type I2CStatus uint32
+
+// Status returns the current state of the I2C peripheral as reported by the
+// IC_STATUS register. Useful to diagnose stuck transfers.
+func (i2c *I2C) Status() I2CStatus {
+	return I2CStatus(i2c.Bus.IC_STATUS.Get())
 }
 
+// Active returns true if the peripheral is in a transfer as controller or
+// target.
+func (s I2CStatus) Active() bool { return s&rp.I2C0_IC_STATUS_ACTIVITY != 0 }
+
+// TxFIFONotFull returns true if there is room in the transmit FIFO.
+func (s I2CStatus) TxFIFONotFull() bool { return s&rp.I2C0_IC_STATUS_TFNF != 0 }
+
+// TxFIFOEmpty returns true if the transmit FIFO is empty.
+func (s I2CStatus) TxFIFOEmpty() bool { return s&rp.I2C0_IC_STATUS_TFE != 0 }
+
+// RxFIFONotEmpty returns true if there is data in the receive FIFO.
+func (s I2CStatus) RxFIFONotEmpty() bool { return s&rp.I2C0_IC_STATUS_RFNE != 0 }
+
+// RxFIFOFull returns true if the receive FIFO is full.
+func (s I2CStatus) RxFIFOFull() bool { return s&rp.I2C0_IC_STATUS_RFF != 0 }
+
+// ControllerActive returns true if the controller state machine is not idle.
+func (s I2CStatus) ControllerActive() bool { return s&rp.I2C0_IC_STATUS_MST_ACTIVITY != 0 }
+
+// TargetActive returns true if the target state machine is not idle.
+func (s I2CStatus) TargetActive() bool { return s&rp.I2C0_IC_STATUS_SLV_ACTIVITY != 0 }
+
 // Listen starts listening for I2C requests sent to specified address
 //
 // addr is the address to listen to
@@ -99,7 +824,9 @@ func (i2c *I2C) Listen(addr uint16) error {
 	if i2c.mode != I2CModeTarget {
 		return ErrI2CWrongMode
 	}
-
+	if err := checkTgtAddr(addr); err != nil {
+		return err
+	}
 	return i2c.listen(uint8(addr))
 }
 
@@ -145,34 +872,208 @@ func (i2c *I2C) Configure(config I2CConfig) error {
 
 	if config.Frequency == 0 {
 		config.Frequency = defaultBaud
+	} else if config.Frequency > i2cMaxBaudRate {
+		// Check before touching the pins, SetBaudRate would fail anyway.
+		return ErrInvalidI2CBaudrate
+	}
+	if config.SDAHoldCount > rp.I2C0_IC_SDA_HOLD_IC_SDA_TX_HOLD_Msk {
+		return ErrInvalidI2CSDAHold
 	}
+	i2c.sdaHoldCount = config.SDAHoldCount
+	i2c.useInterrupts = config.UseInterrupts && config.InterByteDelay == 0
+	i2c.disableIRQ = config.DisableIRQDuringTx
+	i2c.interByteDelay = uint64(config.InterByteDelay / time.Microsecond)
+	i2c.retries = config.Retries
+	i2c.postAbortDelay = uint64(config.PostAbortDelay / time.Microsecond)
+	i2c.scl = config.SCL
 	config.SDA.Configure(PinConfig{PinI2C})
 	config.SCL.Configure(PinConfig{PinI2C})
-	return i2c.init(config)
+	err := i2c.init(config)
+	if err != nil {
+		return err
+	}
+	settle := config.SettleDelay
+	if settle == 0 {
+		settle = 50 * time.Microsecond
+	}
+	deadline := ticks() + uint64(settle/time.Microsecond)
+	for settle > 0 && ticks() < deadline {
+	}
+	return nil
 }
 
 // SetBaudRate sets the I2C frequency. It has the side effect of also
 // enabling the I2C hardware if disabled beforehand.
 //
+// The frequency must be non-zero and no higher than 1MHz (fast mode plus),
+// else ErrInvalidI2CBaudrate is returned and the hardware is left untouched.
+//
 //go:inline
 func (i2c *I2C) SetBaudRate(br uint32) error {
-
-	if br == 0 {
+	if br == 0 || br > i2cMaxBaudRate {
 		return ErrInvalidI2CBaudrate
 	}
 
 	// I2C is synchronous design that runs from clk_sys
 	freqin := CPUFrequency()
 
+	hcnt, lcnt, ok := i2cSCLCounts(freqin, br)
+	if !ok {
+		return ErrInvalidI2CBaudrate
+	}
+	sdaTxHoldCnt := i2cSDAHoldCount(freqin, br)
+	if i2c.sdaHoldCount != 0 {
+		// User override of the computed hold time, see I2CConfig.SDAHoldCount.
+		if i2c.sdaHoldCount > lcnt-2 {
+			return ErrInvalidI2CSDAHold
+		}
+		sdaTxHoldCnt = i2c.sdaHoldCount
+	}
+
+	if sdaTxHoldCnt > lcnt-2 {
+		return ErrInvalidI2CBaudrate
+	}
+	err := i2c.disable()
+	if err != nil {
+		return err
+	}
+	// Always use "fast" mode (<= 400 kHz, works fine for standard mode too)
+
+	i2c.Bus.IC_CON.ReplaceBits(rp.I2C0_IC_CON_SPEED_FAST<<rp.I2C0_IC_CON_SPEED_Pos, rp.I2C0_IC_CON_SPEED_Msk, 0)
+	i2c.Bus.IC_FS_SCL_HCNT.Set(hcnt)
+	i2c.Bus.IC_FS_SCL_LCNT.Set(lcnt)
+
+	i2c.Bus.IC_FS_SPKLEN.Set(u32max(1, lcnt/16))
+
+	i2c.Bus.IC_SDA_HOLD.ReplaceBits(sdaTxHoldCnt<<rp.I2C0_IC_SDA_HOLD_IC_SDA_TX_HOLD_Pos, rp.I2C0_IC_SDA_HOLD_IC_SDA_TX_HOLD_Msk, 0)
+	i2c.enable()
+	i2c.baudRate = br
+	return nil
+}
+
+// I2CTiming holds the SCL timing register values of the peripheral, in clk_sys
+// cycles of 8ns at 125MHz, for ConfigureTiming.
+type I2CTiming struct {
+	// HCNT and LCNT are the SCL high and low periods, IC_FS_SCL_HCNT and
+	// IC_FS_SCL_LCNT. The SCL frequency is CPUFrequency()/(HCNT+LCNT), minus
+	// the rise time of the bus which delays the start of the high period.
+	// The I2C specification requires a low period of at least 1.3us in fast
+	// mode and 0.5us in fast mode plus, and a high period of 0.6us and
+	// 0.26us.
+	HCNT, LCNT uint32
+	// SDAHold is the delay from SCL falling to SDA changing when
+	// transmitting, IC_SDA_HOLD. It must cover the fall time of SCL, at least
+	// 300ns in fast mode and 120ns in fast mode plus, and be at most LCNT-2.
+	SDAHold uint32
+	// SpikeLen is the length of the longest spike suppressed on SCL and SDA,
+	// IC_FS_SPKLEN, at least 1. The specification requires 50ns. HCNT must
+	// exceed SpikeLen+5 and LCNT SpikeLen+7.
+	SpikeLen uint32
+}
+
+// ConfigureTiming programs the SCL timing registers with t as is, instead of
+// deriving them from a frequency like SetBaudRate does. It is meant for
+// characterizing a bus, for example to stretch the low period for slow rise
+// times without lowering the frequency as much as SetBaudRate would.
+//
+// To derive the values, start from the counts SetBaudRate programs, read back
+// with Timing, and measure SCL and SDA with an
+// oscilloscope while adjusting them. Each count is checked against its
+// register width and the constraints documented on I2CTiming. A later call to
+// SetBaudRate or Configure overwrites the values.
+func (i2c *I2C) ConfigureTiming(t I2CTiming) error {
+	if t.SpikeLen == 0 || t.SpikeLen > rp.I2C0_IC_FS_SPKLEN_IC_FS_SPKLEN_Msk ||
+		t.HCNT > rp.I2C0_IC_FS_SCL_HCNT_IC_FS_SCL_HCNT_Msk || t.HCNT <= t.SpikeLen+5 ||
+		t.LCNT > rp.I2C0_IC_FS_SCL_LCNT_IC_FS_SCL_LCNT_Msk || t.LCNT <= t.SpikeLen+7 {
+		return errInvalidI2CTiming
+	}
+	if t.SDAHold > rp.I2C0_IC_SDA_HOLD_IC_SDA_TX_HOLD_Msk>>rp.I2C0_IC_SDA_HOLD_IC_SDA_TX_HOLD_Pos || t.SDAHold > t.LCNT-2 {
+		return ErrInvalidI2CSDAHold
+	}
+	err := i2c.disable()
+	if err != nil {
+		return err
+	}
+	i2c.Bus.IC_CON.ReplaceBits(rp.I2C0_IC_CON_SPEED_FAST<<rp.I2C0_IC_CON_SPEED_Pos, rp.I2C0_IC_CON_SPEED_Msk, 0)
+	i2c.Bus.IC_FS_SCL_HCNT.Set(t.HCNT)
+	i2c.Bus.IC_FS_SCL_LCNT.Set(t.LCNT)
+	i2c.Bus.IC_FS_SPKLEN.Set(t.SpikeLen)
+	i2c.Bus.IC_SDA_HOLD.ReplaceBits(t.SDAHold<<rp.I2C0_IC_SDA_HOLD_IC_SDA_TX_HOLD_Pos, rp.I2C0_IC_SDA_HOLD_IC_SDA_TX_HOLD_Msk, 0)
+	i2c.enable()
+	i2c.baudRate = i2c.ActualBaudrate()
+	return nil
+}
+
+// Timing returns the SCL timing registers as currently programmed, by
+// SetBaudRate or ConfigureTiming.
+func (i2c *I2C) Timing() I2CTiming {
+	return I2CTiming{
+		HCNT:     i2c.Bus.IC_FS_SCL_HCNT.Get(),
+		LCNT:     i2c.Bus.IC_FS_SCL_LCNT.Get(),
+		SDAHold:  (i2c.Bus.IC_SDA_HOLD.Get() & rp.I2C0_IC_SDA_HOLD_IC_SDA_TX_HOLD_Msk) >> rp.I2C0_IC_SDA_HOLD_IC_SDA_TX_HOLD_Pos,
+		SpikeLen: i2c.Bus.IC_FS_SPKLEN.Get(),
+	}
+}
+
+// ActualBaudrate returns the SCL frequency programmed in the peripheral,
+// computed from the SCL high and low counts and clk_sys, like
+// ClosestI2CBaudrate. It differs from the frequency passed to SetBaudRate
+// because the counts are whole clk_sys cycles. It returns 0 if no frequency
+// has been programmed.
+//
+// The frequency seen on the bus is somewhat lower, since the controller only
+// starts counting the high period once it sees SCL high, after the rise time
+// of the bus and the input synchronization delay.
+func (i2c *I2C) ActualBaudrate() uint32 {
+	period := i2c.Bus.IC_FS_SCL_HCNT.Get() + i2c.Bus.IC_FS_SCL_LCNT.Get()
+	if period == 0 {
+		return 0
+	}
+	return CPUFrequency() / period
+}
+
+// ClosestI2CBaudrate returns the SCL frequency that results from calling
+// SetBaudRate with target, which differs slightly from target due to the
+// integer SCL counts. If target is outside of the range supported by the
+// hardware the closest supported frequency is returned and ok is false.
+//
+// This is useful for drivers that request unusual frequencies:
+//
+//	br, _ := machine.ClosestI2CBaudrate(requested)
+//	err := i2c.SetBaudRate(br)
+func ClosestI2CBaudrate(target uint32) (achievable uint32, ok bool) {
+	freqin := CPUFrequency()
+	// Lowest frequency is reached when LCNT saturates.
+	const maxPeriod = rp.I2C0_IC_FS_SCL_LCNT_IC_FS_SCL_LCNT_Msk * 5 / 3
+	minBaud := (freqin + maxPeriod - 1) / maxPeriod
+	br := target
+	switch {
+	case br > i2cMaxBaudRate:
+		br = i2cMaxBaudRate
+	case br < minBaud:
+		br = minBaud
+	}
+	hcnt, lcnt, valid := i2cSCLCounts(freqin, br)
+	if !valid || i2cSDAHoldCount(freqin, br) > lcnt-2 {
+		return 0, false
+	}
+	return freqin / (hcnt + lcnt), br == target
+}
+
+// i2cSCLCounts calculates the SCL high and low periods in clk_sys cycles for
+// baudrate br. ok is false if the periods do not fit the hardware registers.
+func i2cSCLCounts(freqin, br uint32) (hcnt, lcnt uint32, ok bool) {
 	// TODO there are some subtleties to I2C timing which we are completely ignoring here
 	period := (freqin + br/2) / br
-	lcnt := period * 3 / 5 // oof this one hurts
-	hcnt := period - lcnt
+	lcnt = period * 3 / 5 // oof this one hurts
+	hcnt = period - lcnt
 	// Check for out-of-range divisors:
-	if hcnt > rp.I2C0_IC_FS_SCL_HCNT_IC_FS_SCL_HCNT_Msk || hcnt < 8 || lcnt > rp.I2C0_IC_FS_SCL_LCNT_IC_FS_SCL_LCNT_Msk || lcnt < 8 {
-		return ErrInvalidI2CBaudrate
-	}
+	ok = !(hcnt > rp.I2C0_IC_FS_SCL_HCNT_IC_FS_SCL_HCNT_Msk || hcnt < 8 || lcnt > rp.I2C0_IC_FS_SCL_LCNT_IC_FS_SCL_LCNT_Msk || lcnt < 8)
+	return hcnt, lcnt, ok
+}
 
+// i2cSDAHoldCount calculates the SDA hold time in clk_sys cycles for baudrate br.
+func i2cSDAHoldCount(freqin, br uint32) uint32 {
 	// Per I2C-bus specification a device in standard or fast mode must
 	// internally provide a hold time of at least 300ns for the SDA signal to
 	// bridge the undefined region of the falling edge of SCL. A smaller hold
@@ -188,25 +1089,39 @@ func (i2c *I2C) SetBaudRate(br uint32) error {
 		// Add 1 to avoid division truncation.
 		sdaTxHoldCnt = ((freqin * 3) / 25000000) + 1
 	}
+	return sdaTxHoldCnt
+}
 
-	if sdaTxHoldCnt > lcnt-2 {
-		return ErrInvalidI2CBaudrate
+// interByteWait waits for the configured InterByteDelay.
+func (i2c *I2C) interByteWait() {
+	if i2c.interByteDelay == 0 {
+		return
 	}
-	err := i2c.disable()
-	if err != nil {
-		return err
+	deadline := ticks() + i2c.interByteDelay
+	for ticks() < deadline {
 	}
-	// Always use "fast" mode (<= 400 kHz, works fine for standard mode too)
-
-	i2c.Bus.IC_CON.ReplaceBits(rp.I2C0_IC_CON_SPEED_FAST<<rp.I2C0_IC_CON_SPEED_Pos, rp.I2C0_IC_CON_SPEED_Msk, 0)
-	i2c.Bus.IC_FS_SCL_HCNT.Set(hcnt)
-	i2c.Bus.IC_FS_SCL_LCNT.Set(lcnt)
+}
 
-	i2c.Bus.IC_FS_SPKLEN.Set(u32max(1, lcnt/16))
+// postAbortWait waits until the configured PostAbortDelay has passed since the
+// last abort.
+func (i2c *I2C) postAbortWait() {
+	if i2c.postAbortDelay == 0 {
+		return
+	}
+	deadline := i2c.abortTicks + i2c.postAbortDelay
+	for ticks() < deadline {
+		i2c.yield()
+	}
+}
 
-	i2c.Bus.IC_SDA_HOLD.ReplaceBits(sdaTxHoldCnt<<rp.I2C0_IC_SDA_HOLD_IC_SDA_TX_HOLD_Pos, rp.I2C0_IC_SDA_HOLD_IC_SDA_TX_HOLD_Msk, 0)
-	i2c.enable()
-	return nil
+// yield lets other goroutines run while waiting on the bus, unless the
+// transfer runs with interrupts disabled.
+//
+//go:inline
+func (i2c *I2C) yield() {
+	if !i2c.disableIRQ {
+		gosched()
+	}
 }
 
 //go:inline
@@ -237,6 +1152,8 @@ func (i2c *I2C) init(config I2CConfig) error {
 	}
 
 	i2c.mode = config.Mode
+	// Any interrupt driven transfer was cut short by the reset.
+	i2c.xfer = i2cTransfer{}
 
 	// Configure as fast-mode with RepStart support, 7-bit addresses
 	mode := uint32(rp.I2C0_IC_CON_SPEED_FAST<<rp.I2C0_IC_CON_SPEED_Pos) |
@@ -268,6 +1185,184 @@ func (i2c *I2C) reset() {
 	}
 }
 
+// Deinit shuts the I2C peripheral down to save power: the controller is
+// disabled and held in reset. If releasePins is set the SDA and SCL pins are
+// disconnected from the peripheral and left as inputs, so they no longer
+// load the bus. Configure brings the peripheral back up.
+//
+// Any transfer in progress is cut short. The peripheral is put in reset even
+// if it does not disable in time, in which case the error is returned.
+func (i2c *I2C) Deinit(releasePins bool) error {
+	i2c.Bus.IC_INTR_MASK.Set(0)
+	i2c.xfer.busy.Set(0)
+	err := i2c.disable()
+	if releasePins {
+		config := i2c.Config()
+		for _, pin := range [2]Pin{config.SDA, config.SCL} {
+			if pin != NoPin {
+				pin.Configure(PinConfig{Mode: PinInput})
+			}
+		}
+	}
+	i2c.deinit()
+	return err
+}
+
+// Abort cuts short the controller transfer in progress, to unwedge a transfer
+// stuck on a misbehaving target. It can be called from another goroutine or
+// from the other core. The controller flushes the TX FIFO and issues a STOP,
+// and the transfer returns, or on the interrupt driven backend completes
+// with, an I2CAbortError reporting a user abort.
+//
+// A transfer begun with StartRead is ended too, StartRead must be called again
+// before reading more bytes. Abort returns once the controller has completed the abort, or
+// after 10ms if it can't, for instance because SCL is held low by a target.
+func (i2c *I2C) Abort() {
+	const timeout = 10 * 1000
+	i2c.inRead = false
+	if i2c.Bus.IC_ENABLE.Get()&rp.I2C0_IC_ENABLE_ENABLE == 0 {
+		return
+	}
+	// Write through the atomic set alias of the register, so the bit can't
+	// be lost to a read-modify-write of IC_ENABLE on the other core.
+	enableSet := (*volatile.Register32)(unsafe.Pointer(uintptr(unsafe.Pointer(&i2c.Bus.IC_ENABLE)) + 0x2000))
+	enableSet.Set(rp.I2C0_IC_ENABLE_ABORT)
+	// The controller clears ABORT once the STOP is sent and the FIFO flushed.
+	deadline := ticks() + timeout
+	for i2c.Bus.IC_ENABLE.Get()&rp.I2C0_IC_ENABLE_ABORT != 0 && ticks() < deadline {
+	}
+}
+
+// EnsureReady gets the bus working again after a fault, such as a target
+// reset or glitched in the middle of a transfer. It returns right away if the
+// bus is idle, with SDA and SCL high, and the controller is not active.
+//
+// Otherwise it aborts the transfer in progress, waits for a target stretching
+// SCL, then clocks SCL up to 9 times until a target holding SDA low releases
+// it, ending with a STOP condition. The peripheral is then reset and
+// configured again like before, at the frequency last set with SetBaudRate;
+// values set with ConfigureTiming are lost. An error is returned if SDA or
+// SCL is still held low, or if the pins are not known because the bus was not
+// configured as a controller.
+//
+// Interrupt driven transfers in progress are cut short, their callback is not
+// called.
+func (i2c *I2C) EnsureReady() error {
+	if i2c.mode != I2CModeController {
+		return ErrI2CWrongMode
+	}
+	config := i2c.Config()
+	sda, scl := config.SDA, config.SCL
+	if sda == NoPin || scl == NoPin {
+		return errInvalidI2CSDA
+	}
+	idle := i2c.Bus.IC_STATUS.Get()&rp.I2C0_IC_STATUS_MST_ACTIVITY == 0
+	if idle && sda.Get() && scl.Get() {
+		return nil
+	}
+	i2c.Abort()
+	if i2c.sclHeldLow() {
+		return errBusBusy
+	}
+	i2cClockOut(sda, scl)
+
+	if i2c.baudRate != 0 {
+		config.Frequency = i2c.baudRate
+	}
+	// config.SDAHoldCount is the user override, or 0 so the hold time keeps
+	// following the frequency.
+	config.SettleDelay = -1 // Already waited for the lines to settle.
+	if err := i2c.Configure(config); err != nil {
+		return err
+	}
+	if !sda.Get() || !scl.Get() {
+		return errI2CBusStuck
+	}
+	return nil
+}
+
+// TryAcquireBus waits until no other controller uses the bus, for buses shared
+// by several controllers, and returns an error if the bus is still in use
+// after timeout. It returns nil once both lines have stayed high without a
+// START condition for two SCL periods, and at least 20us.
+//
+// IC_STATUS only reports the activity of this controller, so the bus is
+// watched directly: the peripheral detects START conditions issued by any
+// controller, and SDA and SCL are sampled. Waiting for the bus avoids most
+// collisions, but does not reserve it: another controller may start a
+// transfer right after TryAcquireBus returns. Both controllers then start
+// together and the hardware arbitration of the I2C protocol resolves the
+// collision bit by bit; the losing controller aborts with an I2CAbortError
+// reporting lost arbitration, which Tx retries when Retries is set.
+func (i2c *I2C) TryAcquireBus(timeout time.Duration) error {
+	if i2c.mode != I2CModeController {
+		return ErrI2CWrongMode
+	}
+	sda, scl := i2c.Config().SDA, i2c.scl
+	if sda == NoPin || scl == NoPin {
+		return errInvalidI2CSDA
+	}
+	br := i2c.baudRate
+	if br == 0 {
+		br = 100_000
+	}
+	window := uint64(u32max(2_000_000/br, 20))
+	deadline := ticks() + uint64(timeout/time.Microsecond)
+	for !i2c.busIdleFor(sda, scl, window) {
+		if ticks() >= deadline {
+			return errI2CBusActive
+		}
+		i2c.yield()
+	}
+	return nil
+}
+
+// busIdleFor watches the bus for us microseconds and reports whether it stayed
+// idle, returning early on activity.
+func (i2c *I2C) busIdleFor(sda, scl Pin, us uint64) bool {
+	i2c.Bus.IC_CLR_START_DET.Get()
+	end := ticks() + us
+	for ticks() < end {
+		if !sda.Get() || !scl.Get() || i2c.interrupted(rp.I2C0_IC_RAW_INTR_STAT_START_DET) {
+			return false
+		}
+	}
+	return true
+}
+
+// i2cClockOut takes over the bus pins and pulses SCL until a target holding
+// SDA low releases it, at most 9 times, which completes the byte it is sending,
+// then generates a STOP condition. Lines are driven low or released, as done by
+// open drain outputs, at about 100kHz.
+func i2cClockOut(sda, scl Pin) {
+	const halfPeriod = 5 // us
+	wait := func() {
+		deadline := ticks() + halfPeriod
+		for ticks() < deadline {
+		}
+	}
+	sdaMask, sclMask := uint32(1)<<sda, uint32(1)<<scl
+	rp.SIO.GPIO_OE_CLR.Set(sdaMask | sclMask)
+	rp.SIO.GPIO_OUT_CLR.Set(sdaMask | sclMask)
+	sda.setFunc(fnSIO)
+	scl.setFunc(fnSIO)
+	for i := 0; i < 9 && !sda.Get(); i++ {
+		rp.SIO.GPIO_OE_SET.Set(sclMask)
+		wait()
+		rp.SIO.GPIO_OE_CLR.Set(sclMask)
+		wait()
+	}
+	// STOP: SDA rises while SCL is high.
+	rp.SIO.GPIO_OE_SET.Set(sclMask)
+	wait()
+	rp.SIO.GPIO_OE_SET.Set(sdaMask)
+	wait()
+	rp.SIO.GPIO_OE_CLR.Set(sclMask)
+	wait()
+	rp.SIO.GPIO_OE_CLR.Set(sdaMask)
+	wait()
+}
+
 // deinit sets reset bit for I2C. Must call reset to reenable I2C after deinit.
 //
 //go:inline
@@ -284,27 +1379,121 @@ func (i2c *I2C) deinit() (resetVal uint32) {
 	return resetVal
 }
 
-// tx performs blocking write followed by read to I2C bus.
-func (i2c *I2C) tx(addr uint8, tx, rx []byte, timeout_us uint64) (err error) {
+// setTarget addresses the next transfers to addr and clears the status left
+// over by previous transfers, including a STOP_DET from an aborted transfer
+// which would otherwise be mistaken for the end of the next one. It fails early
+// if another device holds SCL low.
+func (i2c *I2C) setTarget(addr uint8) error {
+	i2c.postAbortWait()
+	if i2c.sclHeldLow() {
+		return errBusBusy
+	}
+	err := i2c.disable()
+	if err != nil {
+		return err
+	}
+	i2c.Bus.IC_TAR.Set(uint32(addr))
+	i2c.enable()
+	i2c.Bus.IC_CLR_STOP_DET.Get()
+	i2c.Bus.IC_CLR_RX_OVER.Get()
+	return nil
+}
+
+// MeasureRiseTime measures how long SCL takes to rise once released, to
+// diagnose a marginal bus: a slow rise means the pull-ups are too weak for the
+// bus capacitance. The I2C specification allows at most 1us in standard mode,
+// 300ns in fast mode and 120ns in fast mode plus, measured between 30% and 70%
+// of the supply.
+//
+// SCL is briefly switched to a GPIO and driven low for a few hundred microseconds, then
+// released, and the pin is polled until it reads high, before being given back
+// to the I2C peripheral. Call it only while the bus is idle; targets see a
+// single clock pulse without a START, which they ignore. Interrupts are
+// disabled during the measurement.
+//
+// The result is the time from release until the pad input crosses its
+// threshold, around half of the supply with the Schmitt trigger enabled by
+// Configure. For a bus dominated by its capacitance, that is about 0.8 times
+// the 30-70% rise time of the specification. The polling loop, whose period is
+// calibrated against the microsecond timer, limits the resolution to a few
+// tens of nanoseconds at 125MHz, and the input synchronizers add two clk_sys
+// cycles, so compare results against the limits above rather than read them
+// as exact.
+func (i2c *I2C) MeasureRiseTime() (time.Duration, error) {
+	scl := i2c.scl
+	if scl == NoPin {
+		return 0, errInvalidI2CSCL
+	}
+	if i2c.sclHeldLow() {
+		return 0, errBusBusy
+	}
+	const calIters = 4096
+	const maxIters = 16 * calIters
+	mask := uint32(1) << scl
+	state := interrupt.Disable()
+	rp.SIO.GPIO_OUT_CLR.Set(mask)
+	rp.SIO.GPIO_OE_SET.Set(mask)
+	scl.setFunc(fnSIO)
+	// Calibrate the polling loop while SCL is held low, so it runs for the
+	// full count, which also lets the line settle low.
+	start := ticks()
+	pinPollLevel(scl, true, calIters)
+	calTicks := ticks() - start
+	rp.SIO.GPIO_OE_CLR.Set(mask)
+	n := pinPollLevel(scl, true, maxIters)
+	scl.setFunc(fnI2C)
+	interrupt.Restore(state)
+	if n == maxIters {
+		return 0, errBusBusy
+	}
+	return time.Duration(uint64(n) * calTicks * uint64(time.Microsecond) / calIters), nil
+}
+
+// sclHeldLow reports whether SCL stays low for longer than a few clock periods,
+// which means another controller or a faulty target is holding the bus. The
+// peripheral would otherwise wait silently for SCL to be released, until the
+// transfer times out.
+func (i2c *I2C) sclHeldLow() bool {
+	if i2c.scl == NoPin || i2c.scl.Get() {
+		return false
+	}
+	br := i2c.baudRate
+	if br == 0 {
+		br = 100_000
+	}
+	// Wait out the low phase of a transfer driven by another controller,
+	// and short clock stretches by targets.
+	wait := uint64(u32max(4_000_000/br, 100))
+	deadline := ticks() + wait
+	for ticks() < deadline {
+		if i2c.scl.Get() {
+			return false
+		}
+	}
+	return true
+}
+
+// tx performs blocking write followed by read to I2C bus. n is the number of
+// bytes successfully read into rx, which is less than len(rx) if the transfer
+// was aborted or timed out during the read.
+func (i2c *I2C) tx(addr uint8, tx, rx []byte, timeout_us uint64) (n int, err error) {
 	deadline := ticks() + timeout_us
-	if addr >= 0x80 || isReservedI2CAddr(addr) {
-		return ErrInvalidTgtAddr
+	if err := checkTgtAddr(uint16(addr)); err != nil {
+		return 0, err
 	}
 	txlen := len(tx)
 	rxlen := len(rx)
-	// Quick return if possible.
-	if txlen == 0 && rxlen == 0 {
-		return nil
-	}
 
-	err = i2c.disable()
+	err = i2c.setTarget(addr)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if txlen == 0 && rxlen == 0 {
+		return 0, i2c.probe(deadline)
 	}
-	i2c.Bus.IC_TAR.Set(uint32(addr))
-	i2c.enable()
 	abort := false
-	var abortReason i2cAbortError
+	var abortReason I2CAbortError
+	nackIndex := -1 // Byte of tx being sent when the transfer aborted.
 	txStop := rxlen == 0
 	for txCtr := 0; txCtr < txlen; txCtr++ {
 		if abort {
@@ -312,6 +1501,9 @@ func (i2c *I2C) tx(addr uint8, tx, rx []byte, timeout_us uint64) (err error) {
 		}
 		first := txCtr == 0
 		last := txCtr == txlen-1 && rxlen == 0
+		if !first {
+			i2c.interByteWait()
+		}
 		i2c.Bus.IC_DATA_CMD.Set(
 			(boolToBit(first) << rp.I2C0_IC_DATA_CMD_RESTART_Pos) |
 				(boolToBit(last && txStop) << rp.I2C0_IC_DATA_CMD_STOP_Pos) |
@@ -335,16 +1527,19 @@ func (i2c *I2C) tx(addr uint8, tx, rx []byte, timeout_us uint64) (err error) {
 		// any activity, then with ic_en=0, this bit is set to 0.
 		for !i2c.interrupted(rp.I2C0_IC_RAW_INTR_STAT_TX_EMPTY) {
 			if ticks() > deadline {
-				return errI2CWriteTimeout // If there was a timeout, don't attempt to do anything else.
+				return 0, errI2CWriteTimeout // If there was a timeout, don't attempt to do anything else.
 			}
 
-			gosched()
+			i2c.yield()
 		}
 
 		abortReason = i2c.getAbortReason()
 		if abortReason != 0 {
 			i2c.clearAbortReason()
 			abort = true
+			nackIndex = txCtr
+		} else {
+			i2c.traceByte(tx[txCtr], false)
 		}
 		if abort || last {
 			// If the transaction was aborted or if it completed
@@ -356,12 +1551,12 @@ func (i2c *I2C) tx(addr uint8, tx, rx []byte, timeout_us uint64) (err error) {
 			for !i2c.interrupted(rp.I2C0_IC_RAW_INTR_STAT_STOP_DET) {
 				if ticks() > deadline {
 					if abort {
-						return abortReason
+						return 0, abortError(abortReason, nackIndex)
 					}
-					return errI2CWriteTimeout
+					return 0, errI2CWriteTimeout
 				}
 
-				gosched()
+				i2c.yield()
 			}
 			i2c.Bus.IC_CLR_STOP_DET.Get()
 		}
@@ -370,10 +1565,13 @@ func (i2c *I2C) tx(addr uint8, tx, rx []byte, timeout_us uint64) (err error) {
 	// Midway check for abort. Related issue https://github.com/tinygo-org/tinygo/issues/3671.
 	// The root cause for an abort after writing registers was "tx data no ack" (abort code=8).
 	// If the abort code was not registered then the whole peripheral would remain in disabled state forever.
-	abortReason = i2c.getAbortReason()
-	if abortReason != 0 {
-		i2c.clearAbortReason()
-		abort = true
+	if !abort {
+		abortReason = i2c.getAbortReason()
+		if abortReason != 0 {
+			i2c.clearAbortReason()
+			abort = true
+			nackIndex = txlen - 1
+		}
 	}
 
 	rxStart := txlen == 0
@@ -381,8 +1579,11 @@ func (i2c *I2C) tx(addr uint8, tx, rx []byte, timeout_us uint64) (err error) {
 		for rxCtr := 0; rxCtr < rxlen; rxCtr++ {
 			first := rxCtr == 0
 			last := rxCtr == rxlen-1
+			if !first || txlen > 0 {
+				i2c.interByteWait()
+			}
 			for i2c.writeAvailable() == 0 {
-				gosched()
+				i2c.yield()
 			}
 			i2c.Bus.IC_DATA_CMD.Set(
 				boolToBit(first && rxStart)<<rp.I2C0_IC_DATA_CMD_RESTART_Pos |
@@ -396,39 +1597,86 @@ func (i2c *I2C) tx(addr uint8, tx, rx []byte, timeout_us uint64) (err error) {
 					abort = true
 				}
 				if ticks() > deadline {
-					return errI2CReadTimeout // If there was a timeout, don't attempt to do anything else.
+					return n, errI2CReadTimeout // If there was a timeout, don't attempt to do anything else.
 				}
 
-				gosched()
+				i2c.yield()
 			}
 			if abort {
 				break
 			}
 			rx[rxCtr] = uint8(i2c.Bus.IC_DATA_CMD.Get())
+			i2c.traceByte(rx[rxCtr], true)
+			n++
 		}
 	}
-	// From Pico SDK: A lot of things could have just happened due to the ingenious and
-	// creative design of I2C. Try to figure things out.
 	if abort {
-		switch {
-		case abortReason == 0 || abortReason&rp.I2C0_IC_TX_ABRT_SOURCE_ABRT_7B_ADDR_NOACK != 0:
-			// No reported errors - seems to happen if there is nothing connected to the bus.
-			// Address byte not acknowledged
-			err = ErrI2CGeneric
-		case abortReason&rp.I2C0_IC_TX_ABRT_SOURCE_ABRT_TXDATA_NOACK != 0:
-			// Address acknowledged, some data not acknowledged
-			fallthrough
-		default:
-			err = abortReason
+		err = abortError(abortReason, nackIndex)
+	} else if i2c.interrupted(rp.I2C0_IC_RAW_INTR_STAT_RX_OVER) {
+		// Bytes were received while the RX FIFO was full and dropped.
+		i2c.Bus.IC_CLR_RX_OVER.Get()
+		err = errI2CRXOverflow
+	}
+	return n, err
+}
+
+// traceStart calls the OnStart hook, if set.
+func (i2c *I2C) traceStart(addr uint16) {
+	if i2c.OnStart != nil {
+		i2c.OnStart(addr)
+	}
+}
+
+// traceByte calls the OnByte hook, if set.
+func (i2c *I2C) traceByte(b byte, read bool) {
+	if i2c.OnByte != nil {
+		i2c.OnByte(b, read)
+	}
+}
+
+// traceEnd calls the OnStop hook if err is nil, else the OnAbort hook, if set.
+func (i2c *I2C) traceEnd(err error) {
+	if err == nil {
+		if i2c.OnStop != nil {
+			i2c.OnStop()
 		}
+	} else if i2c.OnAbort != nil {
+		i2c.OnAbort(err)
 	}
-	return err
+}
+
+// probe performs a zero-length transfer to the target set in IC_TAR, returning
+// an error if it does not acknowledge its address.
+//
+// The controller cannot issue an address phase on its own, every command in
+// IC_DATA_CMD carries a data byte. The least intrusive alternative is a
+// single byte read: nothing is written to the target, which is told to stop
+// sending by the NACK the controller issues on the last read byte.
+func (i2c *I2C) probe(deadline uint64) error {
+	i2c.Bus.IC_DATA_CMD.Set(rp.I2C0_IC_DATA_CMD_RESTART | rp.I2C0_IC_DATA_CMD_STOP | rp.I2C0_IC_DATA_CMD_CMD)
+	for !i2c.interrupted(rp.I2C0_IC_RAW_INTR_STAT_STOP_DET) {
+		if ticks() > deadline {
+			return errI2CReadTimeout
+		}
+		i2c.yield()
+	}
+	i2c.Bus.IC_CLR_STOP_DET.Get()
+	abortReason := i2c.getAbortReason()
+	if abortReason != 0 {
+		i2c.clearAbortReason()
+		return abortError(abortReason, -1)
+	}
+	// Discard the byte read.
+	for i2c.readAvailable() > 0 {
+		i2c.Bus.IC_DATA_CMD.Get()
+	}
+	return nil
 }
 
 // listen sets up for async handling of requests on the I2C bus.
 func (i2c *I2C) listen(addr uint8) error {
-	if addr >= 0x80 || isReservedI2CAddr(addr) {
-		return ErrInvalidTgtAddr
+	if err := checkTgtAddr(uint16(addr)); err != nil {
+		return err
 	}
 
 	err := i2c.disable()
@@ -533,21 +1781,31 @@ func (i2c *I2C) readAvailable() uint32 {
 	return i2c.Bus.IC_RXFLR.Get()
 }
 
-// Equivalent to IC_CLR_TX_ABRT.Get() (side effect clears ABORT_REASON)
+// Equivalent to IC_CLR_TX_ABRT.Get() (side effect clears ABORT_REASON). Also
+// records the reason for LastAbort and the time for PostAbortDelay.
 //
 //go:inline
 func (i2c *I2C) clearAbortReason() {
 	// Note clearing the abort flag also clears the reason, and
 	// this instance of flag is clear-on-read! Note also the
 	// IC_CLR_TX_ABRT register always reads as 0.
+	i2c.lastAbort = i2c.getAbortReason()
 	i2c.Bus.IC_CLR_TX_ABRT.Get()
+	i2c.abortTicks = ticks()
+}
+
+// LastAbort returns the reason of the last transfer aborted by the controller.
+// It tells apart the causes of ErrI2CGeneric, which Tx returns without a
+// reason when the target does not acknowledge its address.
+func (i2c *I2C) LastAbort() I2CAbortError {
+	return i2c.lastAbort
 }
 
 // getAbortReason reads IC_TX_ABRT_SOURCE register.
 //
 //go:inline
-func (i2c *I2C) getAbortReason() i2cAbortError {
-	return i2cAbortError(i2c.Bus.IC_TX_ABRT_SOURCE.Get())
+func (i2c *I2C) getAbortReason() I2CAbortError {
+	return I2CAbortError(i2c.Bus.IC_TX_ABRT_SOURCE.Get())
 }
 
 // returns true if RAW_INTR_STAT bits in mask are all set. performs:
@@ -560,13 +1818,79 @@ func (i2c *I2C) interrupted(mask uint32) bool {
 	return reg&mask == mask
 }
 
-type i2cAbortError uint32
+// isRetryable reports whether err is due to a transient bus condition after
+// which the transfer can be repeated.
+func (i2c *I2C) isRetryable(err error) bool {
+	if err == ErrI2CGeneric {
+		return i2c.lastAbort == 0
+	}
+	abort, ok := err.(I2CAbortError)
+	return ok && abort&rp.I2C0_IC_TX_ABRT_SOURCE_ARB_LOST != 0
+}
+
+// I2CDataNackError is returned when the target does not acknowledge a byte
+// written by Tx, which can happen on long or noisy buses. The controller
+// always ends the transfer with a STOP, so the bus is left idle and the next
+// transfer can proceed normally, possibly resending w from Index.
+//
+// It unwraps to the I2CAbortError of the transfer.
+type I2CDataNackError struct {
+	// Index is the index in w of the byte which was not acknowledged.
+	Index int
+	Abort I2CAbortError
+}
+
+func (e I2CDataNackError) Error() string {
+	return e.Abort.Error() + " at byte " + itoa.Itoa(e.Index)
+}
+
+// Unwrap returns the abort error, for use with errors.Is and errors.As.
+func (e I2CDataNackError) Unwrap() error {
+	return e.Abort
+}
 
-func (b i2cAbortError) Error() string {
-	return "i2c abort, reason " + itoa.Uitoa(uint(b))
+// abortError returns the error of an aborted transfer: ErrI2CGeneric if abort
+// is 0 or due to the address not acknowledged, an I2CDataNackError if it is due
+// to the target not acknowledging the byte at index, and abort otherwise.
+func abortError(abort I2CAbortError, index int) error {
+	if abort == 0 || abort&rp.I2C0_IC_TX_ABRT_SOURCE_ABRT_7B_ADDR_NOACK != 0 {
+		return ErrI2CGeneric
+	}
+	if index < 0 || abort&rp.I2C0_IC_TX_ABRT_SOURCE_ABRT_TXDATA_NOACK == 0 {
+		return abort
+	}
+	return I2CDataNackError{Index: index, Abort: abort}
+}
+
+// I2CAbortError is returned when the controller aborts a transfer. Its value
+// is the raw IC_TX_ABRT_SOURCE register, which Reasons and Error decode.
+//
+// Aborts due to the target not acknowledging its address are reported as
+// ErrI2CGeneric instead, as they were before I2CAbortError was added; LastAbort
+// returns their reason. Such values still match ErrI2CGeneric with errors.Is.
+type I2CAbortError uint32
+
+// Is reports whether the abort matches target, for use with errors.Is.
+func (b I2CAbortError) Is(target error) bool {
+	// From Pico SDK: A lot of things could have just happened due to the ingenious and
+	// creative design of I2C. No reported errors seems to happen if there is nothing
+	// connected to the bus, which is the same as the address byte not acknowledged.
+	return target == ErrI2CGeneric && (b == 0 || b&rp.I2C0_IC_TX_ABRT_SOURCE_ABRT_7B_ADDR_NOACK != 0)
+}
+
+func (b I2CAbortError) Error() string {
+	reasons := b.Reasons()
+	if len(reasons) == 0 {
+		return "i2c abort, reason " + itoa.Uitoa(uint(b))
+	}
+	msg := "i2c abort: " + reasons[0]
+	for _, reason := range reasons[1:] {
+		msg += ", " + reason
+	}
+	return msg
 }
 
-func (b i2cAbortError) Reasons() (reasons []string) {
+func (b I2CAbortError) Reasons() (reasons []string) {
 	if b == 0 {
 		return nil
 	}
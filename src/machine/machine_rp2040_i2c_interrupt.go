@@ -0,0 +1,264 @@
+//go:build rp2040
+
+package machine
+
+import (
+	"device/rp"
+	"runtime/interrupt"
+	"runtime/volatile"
+)
+
+// Interrupt driven controller transfers. Instead of busy-waiting on the raw
+// interrupt status like tx does, the TX FIFO is refilled and the RX FIFO
+// drained from the I2C interrupt handler, which also detects the end of the
+// transfer via STOP_DET.
+
+// i2cTransfer is the state of a transfer on the interrupt driven backend. It
+// is shared between the interrupt handler and the caller.
+type i2cTransfer struct {
+	w, r []byte
+	// Number of bytes of w written to the TX FIFO.
+	wIdx int
+	// Number of read commands written to the TX FIFO.
+	rCmdIdx int
+	// Number of bytes read into r.
+	rIdx int
+	err  error
+	// done is called from the interrupt handler once the transfer completes.
+	done func(n int, err error)
+	// busy is non-zero while a transfer is in progress.
+	busy volatile.Register8
+}
+
+// Interrupts used by the interrupt driven backend.
+const i2cTransferIntrMask = rp.I2C0_IC_INTR_MASK_M_TX_EMPTY | rp.I2C0_IC_INTR_MASK_M_RX_FULL |
+	rp.I2C0_IC_INTR_MASK_M_TX_ABRT | rp.I2C0_IC_INTR_MASK_M_STOP_DET | rp.I2C0_IC_INTR_MASK_M_RX_OVER
+
+// StartTx starts a write followed by a read transfer like Tx but returns
+// immediately. The transfer is carried out from the I2C interrupt and done is
+// called once it completes, with the number of bytes read into r and the
+// error, if any. done runs in interrupt context so it must not block or
+// allocate. w and r must not be modified until done is called.
+//
+// StartTx returns ErrI2CBusy if a transfer is already in progress.
+func (i2c *I2C) StartTx(addr uint16, w, r []byte, done func(n int, err error)) error {
+	if i2c.mode != I2CModeController {
+		return ErrI2CWrongMode
+	}
+	if err := checkTgtAddr(addr); err != nil {
+		return err
+	}
+	return i2c.startTx(uint8(addr), w, r, done)
+}
+
+// txInterrupt performs a transfer on the interrupt driven backend and waits
+// for it to complete.
+func (i2c *I2C) txInterrupt(addr uint8, w, r []byte, timeout_us uint64) (n int, err error) {
+	deadline := ticks() + timeout_us
+	if len(w) == 0 && len(r) == 0 {
+		// Probing the address is done with a single read, see probe.
+		return i2c.tx(addr, w, r, timeout_us)
+	}
+	err = i2c.startTx(addr, w, r, nil)
+	if err != nil {
+		return 0, err
+	}
+	return i2c.waitTx(len(w), deadline)
+}
+
+// TxAsync starts a write followed by a read transfer like Tx on the interrupt
+// driven backend and returns a channel which receives the result once the
+// transfer completes, so a goroutine can select on it alongside other events:
+//
+//	select {
+//	case err := <-i2c.TxAsync(addr, w, r):
+//		// ...
+//	case <-quit:
+//		i2c.Abort()
+//	}
+//
+// The channel is buffered and always receives exactly one value, which is
+// nil on success. It is sent by the I2C interrupt handler when the transfer
+// completes, no goroutine is started; only probing an address, with w and r
+// empty, is done before TxAsync returns. w and r must not be modified until
+// the result is received.
+//
+// Unlike Tx, the transfer has no timeout, it lasts until the controller ends
+// it with a STOP. Abort cuts it short, the channel then receives an
+// I2CAbortError.
+func (i2c *I2C) TxAsync(addr uint16, w, r []byte) <-chan error {
+	result := make(chan error, 1)
+	if i2c.mode != I2CModeController {
+		result <- ErrI2CWrongMode
+		return result
+	}
+	if err := checkTgtAddr(addr); err != nil {
+		result <- err
+		return result
+	}
+	if len(w) == 0 && len(r) == 0 {
+		// Probing the address is a short blocking transfer, see probe.
+		const timeout = 40 * 1000 // See Tx.
+		_, err := i2c.txInterrupt(uint8(addr), w, r, timeout)
+		result <- err
+		return result
+	}
+	err := i2c.startTx(uint8(addr), w, r, func(n int, err error) {
+		// Never blocks, the buffer is empty until this single send.
+		select {
+		case result <- err:
+		default:
+		}
+	})
+	if err != nil {
+		result <- err
+	}
+	return result
+}
+
+// waitTx waits for the transfer started with startTx to complete, yielding to
+// other goroutines, and abandons it at deadline. wlen is the length of the
+// write part of the transfer.
+func (i2c *I2C) waitTx(wlen int, deadline uint64) (n int, err error) {
+	x := &i2c.xfer
+	for x.busy.Get() != 0 {
+		if ticks() > deadline {
+			i2c.Bus.IC_INTR_MASK.Set(0)
+			// The interrupt handler can no longer touch the state.
+			x.busy.Set(0)
+			if x.wIdx < wlen {
+				return 0, errI2CWriteTimeout
+			}
+			return x.rIdx, errI2CReadTimeout
+		}
+		gosched()
+	}
+	return x.rIdx, x.err
+}
+
+// startTx sets up the transfer state and enables the interrupts which drive
+// it.
+func (i2c *I2C) startTx(addr uint8, w, r []byte, done func(n int, err error)) error {
+	if err := checkTgtAddr(uint16(addr)); err != nil {
+		return err
+	}
+	x := &i2c.xfer
+	if x.busy.Get() != 0 {
+		return ErrI2CBusy
+	}
+	if len(w) == 0 && len(r) == 0 {
+		if done != nil {
+			done(0, nil)
+		}
+		return nil
+	}
+	err := i2c.setTarget(addr)
+	if err != nil {
+		return err
+	}
+	// Clear stale interrupts and abort reason from previous transfers.
+	i2c.Bus.IC_CLR_INTR.Get()
+
+	*x = i2cTransfer{w: w, r: r, done: done}
+	x.busy.Set(1)
+	switch i2c.Bus {
+	case rp.I2C0:
+		interrupt.New(rp.IRQ_I2C0_IRQ, i2c0HandleInterrupt).Enable()
+	case rp.I2C1:
+		interrupt.New(rp.IRQ_I2C1_IRQ, i2c1HandleInterrupt).Enable()
+	}
+	// TX_EMPTY fires immediately since the FIFO is empty, which starts the
+	// transfer from the interrupt handler.
+	i2c.Bus.IC_INTR_MASK.Set(i2cTransferIntrMask)
+	return nil
+}
+
+func i2c0HandleInterrupt(intr interrupt.Interrupt) {
+	I2C0.handleInterrupt()
+}
+
+func i2c1HandleInterrupt(intr interrupt.Interrupt) {
+	I2C1.handleInterrupt()
+}
+
+// handleInterrupt advances the transfer in progress.
+func (i2c *I2C) handleInterrupt() {
+	x := &i2c.xfer
+	stat := i2c.Bus.IC_INTR_STAT.Get()
+	if x.busy.Get() == 0 {
+		// Transfer was abandoned, see txInterrupt.
+		i2c.Bus.IC_INTR_MASK.Set(0)
+		return
+	}
+
+	if stat&rp.I2C0_IC_INTR_STAT_R_TX_ABRT != 0 {
+		x.err = abortError(i2c.getAbortReason(), -1)
+		i2c.clearAbortReason()
+		// The TX FIFO was flushed; the controller issues a STOP which
+		// finishes the transfer below.
+		i2c.Bus.IC_INTR_MASK.ClearBits(rp.I2C0_IC_INTR_MASK_M_TX_EMPTY | rp.I2C0_IC_INTR_MASK_M_RX_FULL)
+	}
+
+	if stat&rp.I2C0_IC_INTR_STAT_R_RX_OVER != 0 {
+		// The handler ran too late to drain the RX FIFO and bytes were
+		// dropped. The transfer carries on but r is corrupted.
+		i2c.Bus.IC_CLR_RX_OVER.Get()
+		if x.err == nil {
+			x.err = errI2CRXOverflow
+		}
+	}
+
+	if stat&rp.I2C0_IC_INTR_STAT_R_RX_FULL != 0 {
+		for i2c.readAvailable() > 0 {
+			b := uint8(i2c.Bus.IC_DATA_CMD.Get())
+			if x.rIdx < len(x.r) {
+				x.r[x.rIdx] = b
+				x.rIdx++
+			}
+		}
+	}
+
+	if stat&rp.I2C0_IC_INTR_STAT_R_TX_EMPTY != 0 && stat&rp.I2C0_IC_INTR_STAT_R_TX_ABRT == 0 {
+		wlen, rlen := len(x.w), len(x.r)
+		for i2c.writeAvailable() > 0 && (x.wIdx < wlen || x.rCmdIdx < rlen) {
+			if x.wIdx < wlen {
+				first := x.wIdx == 0
+				last := x.wIdx == wlen-1 && rlen == 0
+				i2c.Bus.IC_DATA_CMD.Set(
+					boolToBit(first)<<rp.I2C0_IC_DATA_CMD_RESTART_Pos |
+						boolToBit(last)<<rp.I2C0_IC_DATA_CMD_STOP_Pos |
+						uint32(x.w[x.wIdx]))
+				x.wIdx++
+				continue
+			}
+			// The controller issues a RESTART on its own when changing
+			// direction after the write.
+			first := x.rCmdIdx == 0 && wlen == 0
+			last := x.rCmdIdx == rlen-1
+			i2c.Bus.IC_DATA_CMD.Set(
+				boolToBit(first)<<rp.I2C0_IC_DATA_CMD_RESTART_Pos |
+					boolToBit(last)<<rp.I2C0_IC_DATA_CMD_STOP_Pos |
+					rp.I2C0_IC_DATA_CMD_CMD)
+			x.rCmdIdx++
+		}
+		if x.wIdx == wlen && x.rCmdIdx == rlen {
+			// All commands queued, wait for data and STOP.
+			i2c.Bus.IC_INTR_MASK.ClearBits(rp.I2C0_IC_INTR_MASK_M_TX_EMPTY)
+		}
+	}
+
+	if stat&rp.I2C0_IC_INTR_STAT_R_STOP_DET != 0 {
+		i2c.Bus.IC_CLR_STOP_DET.Get()
+		// Pick up bytes received along with the STOP.
+		for i2c.readAvailable() > 0 && x.rIdx < len(x.r) {
+			x.r[x.rIdx] = uint8(i2c.Bus.IC_DATA_CMD.Get())
+			x.rIdx++
+		}
+		i2c.Bus.IC_INTR_MASK.Set(0)
+		done := x.done
+		x.busy.Set(0)
+		if done != nil {
+			done(x.rIdx, x.err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+//go:build rp2040
+
+package machine
+
+import (
+	"device/rp"
+	"errors"
+)
+
+var errPinGroupPins = errors.New("pin group needs 1 to 30 distinct GPIO pins")
+
+// PinGroup drives several output pins as the bits of a word, for bit-banged
+// parallel buses such as 8080 LCD interfaces. Bit i of a value is driven on
+// pins[i].
+//
+// The group keeps the output levels of its pins in a cached word, so updates
+// don't read GPIO_OUT back: each one is a single write to the SIO set, clear
+// or XOR alias, which is atomic and leaves the other pins of the register
+// untouched, whichever core or interrupt handler drives them.
+//
+// The group owns its pins exclusively: their levels must only be changed
+// through it, otherwise the cache goes stale and Write toggles the wrong bits.
+type PinGroup struct {
+	pins []Pin
+	// Mask of all pins in the SIO registers.
+	mask uint32
+	// Cached GPIO_OUT bits of the pins.
+	out uint32
+	// Shift from value bits to GPIO bits if the pins are consecutive in
+	// ascending order, -1 otherwise.
+	shift int
+}
+
+// NewPinGroup configures pins as outputs driven low and returns a PinGroup
+// driving them.
+func NewPinGroup(pins []Pin) (*PinGroup, error) {
+	if len(pins) == 0 {
+		return nil, errPinGroupPins
+	}
+	var mask uint32
+	shift := int(pins[0])
+	for i, p := range pins {
+		if p >= _NUMBANK0_GPIOS || mask&(1<<p) != 0 {
+			return nil, errPinGroupPins
+		}
+		mask |= 1 << p
+		if int(p) != int(pins[0])+i {
+			shift = -1
+		}
+	}
+	rp.SIO.GPIO_OUT_CLR.Set(mask)
+	for _, p := range pins {
+		p.Configure(PinConfig{Mode: PinOutput})
+	}
+	return &PinGroup{pins: pins, mask: mask, shift: shift}, nil
+}
+
+// SetBit drives the pin of bit i high. Out of range bits are ignored.
+func (g *PinGroup) SetBit(i int) {
+	if i < 0 || i >= len(g.pins) {
+		return
+	}
+	bit := uint32(1) << g.pins[i]
+	g.out |= bit
+	rp.SIO.GPIO_OUT_SET.Set(bit)
+}
+
+// ClearBit drives the pin of bit i low. Out of range bits are ignored.
+func (g *PinGroup) ClearBit(i int) {
+	if i < 0 || i >= len(g.pins) {
+		return
+	}
+	bit := uint32(1) << g.pins[i]
+	g.out &^= bit
+	rp.SIO.GPIO_OUT_CLR.Set(bit)
+}
+
+// Write drives all pins of the group at once with the low len(pins) bits of
+// value. Pins which change level switch on the same clock cycle.
+func (g *PinGroup) Write(value uint32) {
+	var out uint32
+	if g.shift >= 0 {
+		out = value << g.shift & g.mask
+	} else {
+		for i, p := range g.pins {
+			out |= (value >> i & 1) << p
+		}
+	}
+	rp.SIO.GPIO_OUT_XOR.Set(out ^ g.out)
+	g.out = out
+}
@@ -0,0 +1,437 @@
+//go:build rp2040
+
+package machine
+
+import (
+	"device/rp"
+	"errors"
+	"runtime/interrupt"
+	"runtime/volatile"
+	"unsafe"
+)
+
+// machine_rp2040_pio.go contains the low level access to the two
+// programmable I/O (PIO) blocks of the RP2040. Each PIO block has four state
+// machines which share 32 words of instruction memory.
+
+var (
+	errPIOShiftThreshold = errors.New("pio: shift threshold must be in 1..32")
+	errPIOInvalidSM      = errors.New("pio: invalid state machine index")
+	errPIONoSpace        = errors.New("pio: not enough instruction memory for program")
+	errPIONoSM           = errors.New("pio: no state machine available")
+)
+
+const (
+	// Number of state machines per PIO block.
+	_NUMSTATEMACHINES = 4
+	// Number of instructions that fit in the instruction memory of a PIO block.
+	_PIOINSTRUCTIONS = 32
+)
+
+type pioSMType struct {
+	clkdiv    volatile.Register32
+	execctrl  volatile.Register32
+	shiftctrl volatile.Register32
+	addr      volatile.Register32
+	instr     volatile.Register32
+	pinctrl   volatile.Register32
+}
+
+type pioIRQCtrl struct {
+	intE volatile.Register32
+	intF volatile.Register32
+	intS volatile.Register32
+}
+
+type pioType struct {
+	ctrl            volatile.Register32
+	fstat           volatile.Register32
+	fdebug          volatile.Register32
+	flevel          volatile.Register32
+	txf             [_NUMSTATEMACHINES]volatile.Register32
+	rxf             [_NUMSTATEMACHINES]volatile.Register32
+	irq             volatile.Register32
+	irqForce        volatile.Register32
+	inputSyncBypass volatile.Register32
+	dbgPadout       volatile.Register32
+	dbgPadoe        volatile.Register32
+	dbgCfginfo      volatile.Register32
+	instrMem        [_PIOINSTRUCTIONS]volatile.Register32
+	sm              [_NUMSTATEMACHINES]pioSMType
+	intR            volatile.Register32
+	irq0            pioIRQCtrl
+	irq1            pioIRQCtrl
+}
+
+// PIO is one of the two programmable I/O blocks of the RP2040.
+type PIO struct {
+	hw *pioType
+	// Bitmask of instruction memory locations in use by loaded programs.
+	usedInstrMem uint32
+	// Bitmask of state machines claimed with ClaimStateMachine.
+	claimedSMs uint8
+}
+
+// PIO blocks available on the RP2040.
+var (
+	PIO0 = &PIO{hw: (*pioType)(unsafe.Pointer(rp.PIO0))}
+	PIO1 = &PIO{hw: (*pioType)(unsafe.Pointer(rp.PIO1))}
+)
+
+// StateMachine is one of the four state machines of a PIO block.
+type StateMachine struct {
+	pio   *PIO
+	index uint8
+}
+
+// StateMachine returns the state machine with the given index (0..3) of this
+// PIO block.
+func (pio *PIO) StateMachine(index uint8) (StateMachine, error) {
+	if index >= _NUMSTATEMACHINES {
+		return StateMachine{}, errPIOInvalidSM
+	}
+	return StateMachine{pio: pio, index: index}, nil
+}
+
+// ClaimStateMachine claims a state machine of this PIO block which is not in
+// use by other drivers. It can be returned with StateMachine.Unclaim.
+func (pio *PIO) ClaimStateMachine() (StateMachine, error) {
+	state := interrupt.Disable()
+	defer interrupt.Restore(state)
+	for i := uint8(0); i < _NUMSTATEMACHINES; i++ {
+		if pio.claimedSMs&(1<<i) == 0 {
+			pio.claimedSMs |= 1 << i
+			return StateMachine{pio: pio, index: i}, nil
+		}
+	}
+	return StateMachine{}, errPIONoSM
+}
+
+// Unclaim returns the state machine to the pool used by ClaimStateMachine.
+func (sm StateMachine) Unclaim() {
+	state := interrupt.Disable()
+	sm.pio.claimedSMs &^= 1 << sm.index
+	interrupt.Restore(state)
+}
+
+// PIO returns the PIO block the state machine belongs to.
+func (sm StateMachine) PIO() *PIO {
+	return sm.pio
+}
+
+// Index returns the index of the state machine within its PIO block.
+func (sm StateMachine) Index() uint8 {
+	return sm.index
+}
+
+func (sm StateMachine) hw() *pioSMType {
+	return &sm.pio.hw.sm[sm.index]
+}
+
+// ShiftDir is the direction in which a PIO shift register shifts.
+type ShiftDir uint8
+
+const (
+	// ShiftLeft shifts data out of (or into) the most significant bit first.
+	ShiftLeft ShiftDir = 0
+	// ShiftRight shifts data out of (or into) the least significant bit first.
+	ShiftRight ShiftDir = 1
+)
+
+// SHIFTCTRL register fields. See section 3.7 of the RP2040 datasheet.
+const (
+	pioShiftCtrlAutopush    = 1 << 16
+	pioShiftCtrlAutopull    = 1 << 17
+	pioShiftCtrlInDirPos    = 18
+	pioShiftCtrlOutDirPos   = 19
+	pioShiftCtrlPushThrPos  = 20
+	pioShiftCtrlPullThrPos  = 25
+	pioShiftCtrlThrMsk      = 0x1f
+	pioShiftCtrlFJoinRX     = 1 << 31
+	pioShiftCtrlOutSettings = pioShiftCtrlAutopull | 1<<pioShiftCtrlOutDirPos | pioShiftCtrlThrMsk<<pioShiftCtrlPullThrPos
+	pioShiftCtrlInSettings  = pioShiftCtrlAutopush | 1<<pioShiftCtrlInDirPos | pioShiftCtrlThrMsk<<pioShiftCtrlPushThrPos
+)
+
+// SetOutShift configures the output shift register (OSR) of the state machine.
+//
+// dir is the direction in which the OSR shifts out data. If autopull is true
+// the OSR is automatically refilled from the TX FIFO once threshold bits have
+// been shifted out, which is what allows a DMA channel to keep the state
+// machine fed without PULL instructions. threshold must be in 1..32.
+func (sm StateMachine) SetOutShift(dir ShiftDir, autopull bool, threshold uint8) error {
+	if threshold < 1 || threshold > 32 {
+		return errPIOShiftThreshold
+	}
+	// A threshold of 32 is encoded as 0.
+	sm.hw().shiftctrl.ReplaceBits(boolToBit(autopull)*pioShiftCtrlAutopull|
+		uint32(dir&1)<<pioShiftCtrlOutDirPos|
+		(uint32(threshold)&pioShiftCtrlThrMsk)<<pioShiftCtrlPullThrPos,
+		pioShiftCtrlOutSettings, 0)
+	return nil
+}
+
+// SetInShift configures the input shift register (ISR) of the state machine.
+//
+// dir is the direction in which data is shifted into the ISR. If autopush is
+// true the ISR contents are automatically pushed to the RX FIFO once threshold
+// bits have been shifted in. threshold must be in 1..32.
+func (sm StateMachine) SetInShift(dir ShiftDir, autopush bool, threshold uint8) error {
+	if threshold < 1 || threshold > 32 {
+		return errPIOShiftThreshold
+	}
+	// A threshold of 32 is encoded as 0.
+	sm.hw().shiftctrl.ReplaceBits(boolToBit(autopush)*pioShiftCtrlAutopush|
+		uint32(dir&1)<<pioShiftCtrlInDirPos|
+		(uint32(threshold)&pioShiftCtrlThrMsk)<<pioShiftCtrlPushThrPos,
+		pioShiftCtrlInSettings, 0)
+	return nil
+}
+
+// AddProgram loads a PIO program into the instruction memory of the PIO block
+// and returns the offset at which it was loaded. If origin is negative the
+// program is placed wherever it fits, else it must be loaded at origin.
+//
+// JMP instructions are relocated, so their targets must be relative to the
+// start of the program, as emitted by pioasm.
+func (pio *PIO) AddProgram(instructions []uint16, origin int8) (offset uint8, err error) {
+	n := len(instructions)
+	if n == 0 || n > _PIOINSTRUCTIONS {
+		return 0, errPIONoSpace
+	}
+	mask := uint32(uint64(1)<<n - 1)
+	if origin >= 0 {
+		if int(origin)+n > _PIOINSTRUCTIONS || pio.usedInstrMem&(mask<<origin) != 0 {
+			return 0, errPIONoSpace
+		}
+		offset = uint8(origin)
+	} else {
+		// Like the Pico SDK, fill instruction memory from the top down.
+		found := false
+		for i := _PIOINSTRUCTIONS - n; i >= 0; i-- {
+			if pio.usedInstrMem&(mask<<i) == 0 {
+				offset = uint8(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, errPIONoSpace
+		}
+	}
+	for i, instr := range instructions {
+		if instr&pioInstrBitsMsk == pioInstrBitsJmp {
+			// JMP address is in the 5 least significant bits.
+			instr += uint16(offset)
+		}
+		pio.hw.instrMem[int(offset)+i].Set(uint32(instr))
+	}
+	pio.usedInstrMem |= mask << offset
+	return offset, nil
+}
+
+// removeProgram marks the instruction memory used by a program loaded with
+// AddProgram as free. Nothing may be executing the program.
+func (pio *PIO) removeProgram(offset, length uint8) {
+	mask := uint32(uint64(1)<<length - 1)
+	pio.usedInstrMem &^= mask << offset
+}
+
+// StateMachineConfig holds the configuration of a state machine, to be applied
+// with StateMachine.Init. Start from DefaultStateMachineConfig.
+type StateMachineConfig struct {
+	clkdiv    uint32
+	execctrl  uint32
+	shiftctrl uint32
+	pinctrl   uint32
+}
+
+// CLKDIV, EXECCTRL and PINCTRL register fields. See section 3.7 of the RP2040
+// datasheet.
+const (
+	pioClkdivFracPos = 8
+	pioClkdivIntPos  = 16
+
+	pioExecctrlWrapBottomPos = 7
+	pioExecctrlWrapTopPos    = 12
+	pioExecctrlWrapMsk       = 0x1f
+	pioExecctrlOutSticky     = 1 << 17
+	pioExecctrlJmpPinPos     = 24
+	pioExecctrlSidePindir    = 1 << 29
+	pioExecctrlSideEn        = 1 << 30
+
+	pioPinctrlOutBasePos      = 0
+	pioPinctrlSetBasePos      = 5
+	pioPinctrlSidesetBasePos  = 10
+	pioPinctrlInBasePos       = 15
+	pioPinctrlOutCountPos     = 20
+	pioPinctrlSetCountPos     = 26
+	pioPinctrlSidesetCountPos = 29
+	pioPinctrlBaseMsk         = 0x1f
+)
+
+// DefaultStateMachineConfig returns the reset configuration of a state
+// machine: clock divider of 1, wrapping over the whole instruction memory,
+// both shift registers shifting right with a threshold of 32 and no pins
+// mapped.
+func DefaultStateMachineConfig() StateMachineConfig {
+	return StateMachineConfig{
+		clkdiv:    1 << pioClkdivIntPos,
+		execctrl:  (_PIOINSTRUCTIONS - 1) << pioExecctrlWrapTopPos,
+		shiftctrl: uint32(ShiftRight)<<pioShiftCtrlInDirPos | uint32(ShiftRight)<<pioShiftCtrlOutDirPos,
+	}
+}
+
+// SetClkDivIntFrac sets the state machine clock divider to whole + frac/256.
+// A whole part of 0 means 65536.
+func (cfg *StateMachineConfig) SetClkDivIntFrac(whole uint16, frac uint8) {
+	cfg.clkdiv = uint32(whole)<<pioClkdivIntPos | uint32(frac)<<pioClkdivFracPos
+}
+
+// SetWrap sets the wrap range of the program. After executing the instruction
+// at wrap the state machine continues at wrapTarget. Both are absolute
+// instruction memory addresses.
+func (cfg *StateMachineConfig) SetWrap(wrapTarget, wrap uint8) {
+	cfg.execctrl = cfg.execctrl&^(pioExecctrlWrapMsk<<pioExecctrlWrapBottomPos|pioExecctrlWrapMsk<<pioExecctrlWrapTopPos) |
+		uint32(wrapTarget&pioExecctrlWrapMsk)<<pioExecctrlWrapBottomPos |
+		uint32(wrap&pioExecctrlWrapMsk)<<pioExecctrlWrapTopPos
+}
+
+// SetOutPins sets the pins affected by OUT instructions.
+func (cfg *StateMachineConfig) SetOutPins(base Pin, count uint8) {
+	cfg.setPinctrl(pioPinctrlOutBasePos, pioPinctrlBaseMsk, uint32(base))
+	cfg.setPinctrl(pioPinctrlOutCountPos, 0x3f, uint32(count))
+}
+
+// SetSetPins sets the pins affected by SET instructions. count is at most 5.
+func (cfg *StateMachineConfig) SetSetPins(base Pin, count uint8) {
+	cfg.setPinctrl(pioPinctrlSetBasePos, pioPinctrlBaseMsk, uint32(base))
+	cfg.setPinctrl(pioPinctrlSetCountPos, 0x7, uint32(count))
+}
+
+// SetInPins sets the first pin read by IN instructions.
+func (cfg *StateMachineConfig) SetInPins(base Pin) {
+	cfg.setPinctrl(pioPinctrlInBasePos, pioPinctrlBaseMsk, uint32(base))
+}
+
+// SetSidesetPins sets the first pin affected by side-set.
+func (cfg *StateMachineConfig) SetSidesetPins(base Pin) {
+	cfg.setPinctrl(pioPinctrlSidesetBasePos, pioPinctrlBaseMsk, uint32(base))
+}
+
+// SetJmpPin sets the pin tested by JMP PIN instructions.
+func (cfg *StateMachineConfig) SetJmpPin(pin Pin) {
+	cfg.execctrl = cfg.execctrl&^(pioPinctrlBaseMsk<<pioExecctrlJmpPinPos) |
+		uint32(pin&pioPinctrlBaseMsk)<<pioExecctrlJmpPinPos
+}
+
+// SetSideset configures side-set as declared in the program with .side_set.
+// bitCount includes the enable bit if optional is true. If pindirs is true
+// side-set drives pin directions instead of pin values.
+func (cfg *StateMachineConfig) SetSideset(bitCount uint8, optional, pindirs bool) {
+	cfg.setPinctrl(pioPinctrlSidesetCountPos, 0x7, uint32(bitCount))
+	cfg.execctrl = cfg.execctrl&^(pioExecctrlSideEn|pioExecctrlSidePindir) |
+		boolToBit(optional)*pioExecctrlSideEn | boolToBit(pindirs)*pioExecctrlSidePindir
+}
+
+func (cfg *StateMachineConfig) setPinctrl(pos uint8, msk, value uint32) {
+	cfg.pinctrl = cfg.pinctrl&^(msk<<pos) | (value&msk)<<pos
+}
+
+// Init disables the state machine, applies cfg, clears its FIFOs and restarts
+// it at initialPC. Enable the state machine with SetEnabled afterwards.
+func (sm StateMachine) Init(initialPC uint8, cfg StateMachineConfig) {
+	sm.SetEnabled(false)
+	hw := sm.hw()
+	hw.clkdiv.Set(cfg.clkdiv)
+	hw.execctrl.Set(cfg.execctrl)
+	hw.shiftctrl.Set(cfg.shiftctrl)
+	hw.pinctrl.Set(cfg.pinctrl)
+
+	sm.ClearFIFOs()
+	// Clear sticky TXSTALL, TXOVER, RXUNDER and RXSTALL debug flags.
+	sm.pio.hw.fdebug.Set((1<<24 | 1<<16 | 1<<8 | 1) << sm.index)
+
+	// Restart the state machine and its clock divider.
+	sm.pio.hw.ctrl.SetBits((1<<4 | 1<<8) << sm.index)
+	sm.Exec(PIOJmp(PIOJmpAlways, initialPC))
+}
+
+// SetEnabled starts or stops the state machine.
+func (sm StateMachine) SetEnabled(enabled bool) {
+	sm.pio.hw.ctrl.ReplaceBits(boolToBit(enabled)<<sm.index, 1<<sm.index, 0)
+}
+
+// PC returns the instruction memory address the state machine is executing.
+func (sm StateMachine) PC() uint8 {
+	return uint8(sm.hw().addr.Get())
+}
+
+// Exec immediately executes instr on the state machine.
+func (sm StateMachine) Exec(instr uint16) {
+	sm.hw().instr.Set(uint32(instr))
+}
+
+// ClearFIFOs empties the TX and RX FIFOs of the state machine.
+func (sm StateMachine) ClearFIFOs() {
+	// Toggling FJOIN_RX flushes both FIFOs.
+	hw := sm.hw()
+	hw.shiftctrl.Set(hw.shiftctrl.Get() ^ pioShiftCtrlFJoinRX)
+	hw.shiftctrl.Set(hw.shiftctrl.Get() ^ pioShiftCtrlFJoinRX)
+}
+
+// IsTxFIFOFull returns true if no more data can be put in the TX FIFO.
+func (sm StateMachine) IsTxFIFOFull() bool {
+	return sm.pio.hw.fstat.HasBits(1 << (16 + sm.index))
+}
+
+// IsRxFIFOEmpty returns true if there is no data in the RX FIFO.
+func (sm StateMachine) IsRxFIFOEmpty() bool {
+	return sm.pio.hw.fstat.HasBits(1 << (8 + sm.index))
+}
+
+// TxPut writes data to the TX FIFO. The FIFO must not be full.
+func (sm StateMachine) TxPut(data uint32) {
+	sm.pio.hw.txf[sm.index].Set(data)
+}
+
+// RxGet reads data from the RX FIFO. The FIFO must not be empty.
+func (sm StateMachine) RxGet() uint32 {
+	return sm.pio.hw.rxf[sm.index].Get()
+}
+
+// SetPindirsConsecutive sets count consecutive pins starting at pin as outputs
+// or inputs of the state machine. The state machine should be disabled.
+func (sm StateMachine) SetPindirsConsecutive(pin Pin, count uint8, isOut bool) {
+	sm.execSetConsecutive(PIOSetPindirs, pin, count, isOut)
+}
+
+// SetPinsConsecutive drives count consecutive pins starting at pin high or
+// low from the state machine. The state machine should be disabled.
+func (sm StateMachine) SetPinsConsecutive(pin Pin, count uint8, level bool) {
+	sm.execSetConsecutive(PIOSetPins, pin, count, level)
+}
+
+// execSetConsecutive executes SET instructions on consecutive pins by
+// temporarily changing the SET pin mapping of the state machine.
+func (sm StateMachine) execSetConsecutive(dest PIOSetDest, pin Pin, count uint8, value bool) {
+	hw := sm.hw()
+	pinctrl := hw.pinctrl.Get()
+	execctrl := hw.execctrl.Get()
+	hw.execctrl.ClearBits(pioExecctrlOutSticky)
+	var data uint8
+	if value {
+		data = 0x1f
+	}
+	for count > 0 {
+		n := count
+		if n > 5 {
+			n = 5 // At most 5 pins per SET instruction.
+		}
+		hw.pinctrl.Set(uint32(n)<<pioPinctrlSetCountPos | uint32(pin&pioPinctrlBaseMsk)<<pioPinctrlSetBasePos)
+		sm.Exec(PIOSet(dest, data))
+		count -= n
+		pin += Pin(n)
+	}
+	hw.pinctrl.Set(pinctrl)
+	hw.execctrl.Set(execctrl)
+}
@@ -0,0 +1,95 @@
+//go:build rp2040
+
+package machine
+
+import (
+	"device/rp"
+	"unsafe"
+)
+
+// CaptureOnEdge samples dataPin on every rising edge of clockPin until buf is
+// full. GPIOs can't pace a DMA channel directly, so a PIO state machine waits
+// for each edge and samples the data pin, and a DMA channel paced by the
+// state machine's RX FIFO moves the samples to buf. Samples are packed 8 per
+// byte, the first one in the most significant bit.
+//
+// Both pins should already be configured as inputs; their functions are not
+// changed. CaptureOnEdge blocks, letting other goroutines run, until buf is
+// full: there is no timeout, so the clock must keep running.
+//
+// The state machine runs at clk_sys and takes 3 cycles per clock period, so
+// the edge rate must stay below CPUFrequency()/3, about 41MHz at 125MHz, with
+// each level of clockPin lasting at least one cycle. dataPin is sampled one
+// cycle after the edge is seen and must be held for at least two cycles. The
+// 8-word joined RX FIFO and the DMA channel, which moves one byte per 8
+// edges, keep up at any rate within that limit.
+//
+// A free state machine and 3 words of instruction memory are taken from PIO0
+// or else PIO1, and a DMA channel is claimed, for the duration of the call.
+func CaptureOnEdge(clockPin, dataPin Pin, buf []byte) error {
+	if len(buf) == 0 {
+		return nil
+	}
+	program := []uint16{
+		PIOWait(false, PIOWaitGPIO, uint8(clockPin)),
+		PIOWait(true, PIOWaitGPIO, uint8(clockPin)),
+		PIOIn(PIOInPins, 1),
+	}
+
+	ch, err := ClaimDMAChannel()
+	if err != nil {
+		return err
+	}
+	defer ch.Unclaim()
+
+	var sm StateMachine
+	var offset uint8
+	for _, pio := range []*PIO{PIO0, PIO1} {
+		sm, err = pio.ClaimStateMachine()
+		if err != nil {
+			continue
+		}
+		offset, err = pio.AddProgram(program, -1)
+		if err == nil {
+			break
+		}
+		sm.Unclaim()
+	}
+	if err != nil {
+		return err
+	}
+	pio := sm.PIO()
+
+	cfg := DefaultStateMachineConfig()
+	cfg.SetInPins(dataPin)
+	cfg.SetWrap(offset, offset+uint8(len(program))-1)
+	sm.Init(offset, cfg)
+	sm.SetInShift(ShiftLeft, true, 8)
+	// The state machine doesn't transmit, give the RX FIFO all 8 entries.
+	sm.hw().shiftctrl.SetBits(pioShiftCtrlFJoinRX)
+
+	// DREQ_PIO0_RX0 is 4, DREQ_PIO1_RX0 is 12.
+	dreq := 4 + uint32(sm.Index())
+	if pio == PIO1 {
+		dreq += 8
+	}
+	// Samples are shifted left into the ISR, so each autopushed byte is in
+	// the least significant byte of the FIFO entry, which a byte read gets.
+	ch.READ_ADDR.Set(uint32(uintptr(unsafe.Pointer(&pio.hw.rxf[sm.Index()]))))
+	ch.WRITE_ADDR.Set(uint32(uintptr(unsafe.Pointer(&buf[0]))))
+	ch.TRANS_COUNT.Set(uint32(len(buf)))
+	ch.CTRL_TRIG.Set(rp.DMA_CH0_CTRL_TRIG_INCR_WRITE |
+		rp.DMA_CH0_CTRL_TRIG_DATA_SIZE_SIZE_BYTE<<rp.DMA_CH0_CTRL_TRIG_DATA_SIZE_Pos |
+		dreq<<rp.DMA_CH0_CTRL_TRIG_TREQ_SEL_Pos |
+		uint32(ch.Index())<<rp.DMA_CH0_CTRL_TRIG_CHAIN_TO_Pos | // Don't chain.
+		rp.DMA_CH0_CTRL_TRIG_EN)
+	sm.SetEnabled(true)
+	for ch.CTRL_TRIG.Get()&rp.DMA_CH0_CTRL_TRIG_BUSY != 0 {
+		gosched()
+	}
+
+	sm.SetEnabled(false)
+	pio.removeProgram(offset, uint8(len(program)))
+	sm.Unclaim()
+	return nil
+}
@@ -0,0 +1,200 @@
+//go:build rp2040
+
+package machine
+
+import "machine/internal/rp2040pio"
+
+// Encoders for PIO instructions, so programs can be built in Go and loaded with
+// AddProgram or executed with Exec without a separate assembler. Delay and
+// side-set values are ORed with the encoded instruction, for example:
+//
+//	PIOOut(PIOOutPins, 1) | PIOSideSet(1, 0) | PIODelay(1) // out pins, 1 side 0 [1]
+//
+// The encoders don't validate their arguments, out of range values are masked
+// to the width of their field. See section 3.4 of the RP2040 datasheet for the
+// meaning of each instruction. They are implemented in an internal package,
+// which is tested on the host against programs assembled by pioasm.
+
+// PIOJmpCond is the condition of a JMP instruction.
+type PIOJmpCond = rp2040pio.JmpCond
+
+const (
+	PIOJmpAlways      = rp2040pio.JmpAlways      // (no condition)
+	PIOJmpXZero       = rp2040pio.JmpXZero       // !x
+	PIOJmpXNotZeroDec = rp2040pio.JmpXNotZeroDec // x--
+	PIOJmpYZero       = rp2040pio.JmpYZero       // !y
+	PIOJmpYNotZeroDec = rp2040pio.JmpYNotZeroDec // y--
+	PIOJmpXNotEqualY  = rp2040pio.JmpXNotEqualY  // x!=y
+	PIOJmpPin         = rp2040pio.JmpPin         // pin
+	PIOJmpOSRNotEmpty = rp2040pio.JmpOSRNotEmpty // !osre
+)
+
+// PIOWaitSrc is the source of a WAIT instruction.
+type PIOWaitSrc = rp2040pio.WaitSrc
+
+const (
+	PIOWaitGPIO = rp2040pio.WaitGPIO // Absolute GPIO number.
+	PIOWaitPin  = rp2040pio.WaitPin  // Pin relative to the IN pin mapping.
+	PIOWaitIRQ  = rp2040pio.WaitIRQ  // PIO IRQ flag.
+)
+
+// PIOInSrc is the source of an IN instruction.
+type PIOInSrc = rp2040pio.InSrc
+
+const (
+	PIOInPins = rp2040pio.InPins
+	PIOInX    = rp2040pio.InX
+	PIOInY    = rp2040pio.InY
+	PIOInNull = rp2040pio.InNull
+	PIOInISR  = rp2040pio.InISR
+	PIOInOSR  = rp2040pio.InOSR
+)
+
+// PIOOutDest is the destination of an OUT instruction.
+type PIOOutDest = rp2040pio.OutDest
+
+const (
+	PIOOutPins    = rp2040pio.OutPins
+	PIOOutX       = rp2040pio.OutX
+	PIOOutY       = rp2040pio.OutY
+	PIOOutNull    = rp2040pio.OutNull
+	PIOOutPindirs = rp2040pio.OutPindirs
+	PIOOutPC      = rp2040pio.OutPC
+	PIOOutISR     = rp2040pio.OutISR
+	PIOOutExec    = rp2040pio.OutExec
+)
+
+// PIOMovDest is the destination of a MOV instruction.
+type PIOMovDest = rp2040pio.MovDest
+
+const (
+	PIOMovDestPins = rp2040pio.MovDestPins
+	PIOMovDestX    = rp2040pio.MovDestX
+	PIOMovDestY    = rp2040pio.MovDestY
+	PIOMovDestExec = rp2040pio.MovDestExec
+	PIOMovDestPC   = rp2040pio.MovDestPC
+	PIOMovDestISR  = rp2040pio.MovDestISR
+	PIOMovDestOSR  = rp2040pio.MovDestOSR
+)
+
+// PIOMovSrc is the source of a MOV instruction.
+type PIOMovSrc = rp2040pio.MovSrc
+
+const (
+	PIOMovSrcPins   = rp2040pio.MovSrcPins
+	PIOMovSrcX      = rp2040pio.MovSrcX
+	PIOMovSrcY      = rp2040pio.MovSrcY
+	PIOMovSrcNull   = rp2040pio.MovSrcNull
+	PIOMovSrcStatus = rp2040pio.MovSrcStatus
+	PIOMovSrcISR    = rp2040pio.MovSrcISR
+	PIOMovSrcOSR    = rp2040pio.MovSrcOSR
+)
+
+// PIOMovOp is the operation applied by a MOV instruction to the copied value.
+type PIOMovOp = rp2040pio.MovOp
+
+const (
+	PIOMovNone    = rp2040pio.MovNone
+	PIOMovInvert  = rp2040pio.MovInvert  // Bitwise complement (!).
+	PIOMovReverse = rp2040pio.MovReverse // Bit reversal (::).
+)
+
+// PIOSetDest is the destination of a SET instruction.
+type PIOSetDest = rp2040pio.SetDest
+
+const (
+	PIOSetPins    = rp2040pio.SetPins
+	PIOSetX       = rp2040pio.SetX
+	PIOSetY       = rp2040pio.SetY
+	PIOSetPindirs = rp2040pio.SetPindirs
+)
+
+// Opcode of JMP instructions, in the top 3 bits, whose address AddProgram
+// relocates.
+const (
+	pioInstrBitsMsk = 0xe000
+	pioInstrBitsJmp = 0x0000
+)
+
+// PIOJmp encodes a JMP to addr, an absolute address in instruction memory,
+// taken if cond is true.
+func PIOJmp(cond PIOJmpCond, addr uint8) uint16 {
+	return rp2040pio.Jmp(cond, addr)
+}
+
+// PIOWait encodes a WAIT for the GPIO, pin or IRQ flag index to be at
+// polarity. For IRQ flags, index may include the relative bit 0x10.
+func PIOWait(polarity bool, src PIOWaitSrc, index uint8) uint16 {
+	return rp2040pio.Wait(polarity, src, index)
+}
+
+// PIOIn encodes an IN shifting bitCount bits, 1 to 32, from src into the ISR.
+func PIOIn(src PIOInSrc, bitCount uint8) uint16 {
+	return rp2040pio.In(src, bitCount)
+}
+
+// PIOOut encodes an OUT shifting bitCount bits, 1 to 32, from the OSR to dest.
+func PIOOut(dest PIOOutDest, bitCount uint8) uint16 {
+	return rp2040pio.Out(dest, bitCount)
+}
+
+// PIOPush encodes a PUSH of the ISR to the RX FIFO. With ifFull the push only
+// happens once the ISR reached the push threshold; with block the state
+// machine stalls while the RX FIFO is full.
+func PIOPush(ifFull, block bool) uint16 {
+	return rp2040pio.Push(ifFull, block)
+}
+
+// PIOPull encodes a PULL from the TX FIFO to the OSR. With ifEmpty the pull
+// only happens once the OSR reached the pull threshold; with block the state
+// machine stalls while the TX FIFO is empty.
+func PIOPull(ifEmpty, block bool) uint16 {
+	return rp2040pio.Pull(ifEmpty, block)
+}
+
+// PIOMov encodes a MOV copying src to dest with op applied.
+func PIOMov(dest PIOMovDest, op PIOMovOp, src PIOMovSrc) uint16 {
+	return rp2040pio.Mov(dest, op, src)
+}
+
+// PIOIRQSet encodes an IRQ setting flag irq, 0 to 7. With wait the state
+// machine stalls until the flag is cleared again. With relative the flag
+// number is offset by the state machine index, as with the rel keyword.
+func PIOIRQSet(irq uint8, wait, relative bool) uint16 {
+	return rp2040pio.IRQSet(irq, wait, relative)
+}
+
+// PIOIRQClear encodes an IRQ clearing flag irq, see PIOIRQSet.
+func PIOIRQClear(irq uint8, relative bool) uint16 {
+	return rp2040pio.IRQClear(irq, relative)
+}
+
+// PIOSet encodes a SET writing value, 0 to 31, to dest.
+func PIOSet(dest PIOSetDest, value uint8) uint16 {
+	return rp2040pio.Set(dest, value)
+}
+
+// PIONop encodes a NOP, assembled as mov y, y.
+func PIONop() uint16 {
+	return rp2040pio.Nop()
+}
+
+// PIODelay encodes a delay of cycles after the instruction. The field is
+// shared with side-set: with n side-set bits, including the enable bit of an
+// optional side-set, at most 1<<(5-n)-1 cycles can be encoded.
+func PIODelay(cycles uint8) uint16 {
+	return rp2040pio.Delay(cycles)
+}
+
+// PIOSideSet encodes the side-set value of an instruction for a state machine
+// configured with bitCount mandatory side-set bits.
+func PIOSideSet(bitCount, value uint8) uint16 {
+	return rp2040pio.SideSet(bitCount, value)
+}
+
+// PIOSideSetOpt encodes the side-set value of an instruction for a state
+// machine configured with bitCount optional side-set bits, bitCount not
+// counting the enable bit.
+func PIOSideSetOpt(bitCount, value uint8) uint16 {
+	return rp2040pio.SideSetOpt(bitCount, value)
+}
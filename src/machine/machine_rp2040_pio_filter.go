@@ -0,0 +1,106 @@
+//go:build rp2040
+
+package machine
+
+// FilteredInput is a pin input debounced by a PIO state machine. The state
+// machine only changes its view of the pin once the pin has held the new level
+// for a minimum time, so glitches and switch bounce never reach the CPU.
+type FilteredInput struct {
+	pin        Pin
+	sm         StateMachine
+	progOffset uint8
+}
+
+// Clock of the glitch filter state machines, so that a cycle is a microsecond.
+const pioFilterClock = 1 * MHz
+
+// pioFilterProgram tracks the filtered level of the pin in its program
+// counter: the state machine executes instructions 6 to 9 while the filtered
+// level is high and the others while it is low. Y holds the number of
+// 2-cycle checks a new level must pass.
+var pioFilterProgram = []uint16{
+	PIOJmp(PIOJmpPin, 6),                        //  0: initial level
+	PIOWait(true, PIOWaitPin, 0),                //  1: low: wait for rising edge
+	PIOMov(PIOMovDestX, PIOMovNone, PIOMovSrcY), //  2
+	PIOJmp(PIOJmpPin, 5),                        //  3
+	PIOJmp(PIOJmpAlways, 1),                     //  4: glitch, still low
+	PIOJmp(PIOJmpXNotZeroDec, 3),                //  5
+	PIOWait(false, PIOWaitPin, 0),               //  6: high: wait for falling edge
+	PIOMov(PIOMovDestX, PIOMovNone, PIOMovSrcY), //  7
+	PIOJmp(PIOJmpPin, 6),                        //  8: glitch, still high
+	PIOJmp(PIOJmpXNotZeroDec, 8),                //  9
+	PIOJmp(PIOJmpAlways, 1),                     // 10: stable low
+}
+
+// Program counter range, relative to the program start, where the filtered
+// level is high.
+const (
+	pioFilterHighStart = 6
+	pioFilterHighEnd   = 9
+)
+
+// NewGlitchFilter starts a PIO state machine that debounces pin. The pin
+// should already be configured as an input, including a pull up or down if no
+// external pull is provided; its function is not changed.
+//
+// A level is reported by Get once the pin has been stable for minStableCycles
+// microseconds, which is also the latency added to every genuine transition.
+// Pulses shorter than that are ignored. minStableCycles must be at least 2.
+//
+// A free state machine and 11 words of instruction memory are taken from
+// PIO0 or else PIO1.
+func NewGlitchFilter(pin Pin, minStableCycles uint16) (*FilteredInput, error) {
+	var sm StateMachine
+	var offset uint8
+	var err error
+	for _, pio := range []*PIO{PIO0, PIO1} {
+		sm, err = pio.ClaimStateMachine()
+		if err != nil {
+			continue
+		}
+		offset, err = pio.AddProgram(pioFilterProgram, -1)
+		if err == nil {
+			break
+		}
+		sm.Unclaim()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := DefaultStateMachineConfig()
+	cfg.SetInPins(pin)
+	cfg.SetJmpPin(pin)
+	cfg.SetWrap(offset, offset+uint8(len(pioFilterProgram))-1)
+	div := uint64(CPUFrequency()) * 256 / pioFilterClock
+	cfg.SetClkDivIntFrac(uint16(div>>8), uint8(div))
+	sm.Init(offset, cfg)
+
+	// Each check takes 2 cycles.
+	checks := uint32(minStableCycles / 2)
+	if checks > 0 {
+		checks-- // Loop runs once more than X.
+	}
+	sm.TxPut(checks)
+	sm.Exec(PIOPull(false, true))
+	sm.Exec(PIOMov(PIOMovDestY, PIOMovNone, PIOMovSrcOSR))
+	sm.SetEnabled(true)
+	return &FilteredInput{pin: pin, sm: sm, progOffset: offset}, nil
+}
+
+// Get returns the debounced level of the pin.
+func (f *FilteredInput) Get() bool {
+	pc := f.sm.PC() - f.progOffset
+	if pc == 0 {
+		// Initial level not determined yet.
+		return f.pin.Get()
+	}
+	return pc >= pioFilterHighStart && pc <= pioFilterHighEnd
+}
+
+// Close stops the filter and frees its state machine and instruction memory.
+func (f *FilteredInput) Close() {
+	f.sm.SetEnabled(false)
+	f.sm.PIO().removeProgram(f.progOffset, uint8(len(pioFilterProgram)))
+	f.sm.Unclaim()
+}
@@ -0,0 +1,147 @@
+//go:build rp2040
+
+package machine
+
+// PIOSPI is a SPI controller implemented on a PIO state machine. It can use
+// any pins and provides additional SPI buses when SPI0 and SPI1 are taken or
+// not available on the required pins.
+type PIOSPI struct {
+	sm StateMachine
+	// Location of the loaded program in instruction memory.
+	progOffset uint8
+	progLen    uint8
+}
+
+// PIO programs from the pico-examples spi.pio, assembled with side-set of 1
+// bit driving SCK. Each bit takes 4 state machine cycles.
+var (
+	pioSPICPHA0 = []uint16{
+		PIOOut(PIOOutPins, 1) | PIOSideSet(1, 0) | PIODelay(1),
+		PIOIn(PIOInPins, 1) | PIOSideSet(1, 1) | PIODelay(1),
+	}
+	pioSPICPHA1 = []uint16{
+		PIOOut(PIOOutX, 1) | PIOSideSet(1, 0),
+		PIOMov(PIOMovDestPins, PIOMovNone, PIOMovSrcX) | PIOSideSet(1, 1) | PIODelay(1),
+		PIOIn(PIOInPins, 1) | PIOSideSet(1, 0),
+	}
+)
+
+// NewPIOSPI returns a SPI controller running on the given state machine.
+// Call Configure before use.
+func NewPIOSPI(sm StateMachine) *PIOSPI {
+	return &PIOSPI{sm: sm}
+}
+
+// Configure loads the SPI program into the PIO block and sets up the state
+// machine and pins. CPOL and CPHA are taken from config.Mode as for the
+// hardware SPI. Only MSB first transfers are supported.
+func (spi *PIOSPI) Configure(config SPIConfig) error {
+	if config.LSBFirst {
+		return ErrLSBNotSupported
+	}
+	if config.Frequency == 0 {
+		config.Frequency = 4 * MHz
+	}
+	// 4 state machine cycles per bit, expressed as 16.8 fixed point divider.
+	div := uint64(CPUFrequency()) * 256 / (4 * uint64(config.Frequency))
+	if div < 1<<8 || div >= 1<<24 {
+		return ErrSPIBaud
+	}
+
+	sm := spi.sm
+	pio := sm.PIO()
+	sm.SetEnabled(false)
+	if spi.progLen != 0 {
+		pio.removeProgram(spi.progOffset, spi.progLen)
+		spi.progLen = 0
+	}
+	cpha := config.Mode&1 != 0
+	program := pioSPICPHA0
+	if cpha {
+		program = pioSPICPHA1
+	}
+	offset, err := pio.AddProgram(program, -1)
+	if err != nil {
+		return err
+	}
+	spi.progOffset = offset
+	spi.progLen = uint8(len(program))
+
+	cfg := DefaultStateMachineConfig()
+	cfg.SetWrap(offset, offset+spi.progLen-1)
+	cfg.SetSideset(1, false, false)
+	cfg.SetSidesetPins(config.SCK)
+	cfg.SetOutPins(config.SDO, 1)
+	cfg.SetInPins(config.SDI)
+	cfg.SetClkDivIntFrac(uint16(div>>8), uint8(div))
+
+	sm.Init(offset, cfg)
+	sm.SetOutShift(ShiftLeft, true, 8)
+	sm.SetInShift(ShiftLeft, true, 8)
+
+	// SCK and SDO idle low; polarity is applied at the pad.
+	sm.SetPinsConsecutive(config.SCK, 1, false)
+	sm.SetPinsConsecutive(config.SDO, 1, false)
+	sm.SetPindirsConsecutive(config.SCK, 1, true)
+	sm.SetPindirsConsecutive(config.SDO, 1, true)
+	sm.SetPindirsConsecutive(config.SDI, 1, false)
+
+	fn := fnPIO0
+	if pio == PIO1 {
+		fn = fnPIO1
+	}
+	config.SCK.setFunc(fn)
+	config.SDO.setFunc(fn)
+	config.SDI.setFunc(fn)
+	config.SCK.setOutputInverted(config.Mode&2 != 0)
+	// The program samples SDI half a bit after driving SCK so the extra
+	// synchronizer delay is not wanted.
+	pio.hw.inputSyncBypass.SetBits(1 << config.SDI)
+
+	sm.SetEnabled(true)
+	return nil
+}
+
+// Transfer writes a single byte out on the SPI bus and receives a byte at the
+// same time.
+func (spi *PIOSPI) Transfer(w byte) (byte, error) {
+	var r [1]byte
+	err := spi.Tx([]byte{w}, r[:])
+	return r[0], err
+}
+
+// Tx handles read/write operation for the SPI interface. w and r must be the
+// same length unless one of them is nil, in which case zeros are written or
+// the read bytes are discarded.
+func (spi *PIOSPI) Tx(w, r []byte) error {
+	n := len(w)
+	switch {
+	case w == nil:
+		n = len(r)
+	case r != nil && len(r) != len(w):
+		return ErrTxInvalidSliceSize
+	}
+	sm := spi.sm
+	// Every byte written produces a byte in the RX FIFO which must be drained
+	// for the state machine not to stall.
+	txRemain, rxRemain := n, n
+	for txRemain > 0 || rxRemain > 0 {
+		if txRemain > 0 && !sm.IsTxFIFOFull() {
+			var b byte
+			if w != nil {
+				b = w[n-txRemain]
+			}
+			// Shifting left: data is taken from the most significant bits.
+			sm.TxPut(uint32(b) << 24)
+			txRemain--
+		}
+		if rxRemain > 0 && !sm.IsRxFIFOEmpty() {
+			b := uint8(sm.RxGet())
+			if r != nil {
+				r[n-rxRemain] = b
+			}
+			rxRemain--
+		}
+	}
+	return nil
+}
@@ -98,3 +98,9 @@ func (pll *pll) init(refdiv, vcoFreq, postDiv1, postDiv2 uint32) {
 	pll.pwr.ClearBits(rp.PLL_SYS_PWR_POSTDIVPD)
 
 }
+
+// deinit powers down the pll. Nothing may be running from the pll when it is
+// powered down. Calling init brings it back up.
+func (pll *pll) deinit() {
+	pll.pwr.Set(rp.PLL_SYS_PWR_PD | rp.PLL_SYS_PWR_DSMPD | rp.PLL_SYS_PWR_POSTDIVPD | rp.PLL_SYS_PWR_VCOPD)
+}
@@ -0,0 +1,61 @@
+//go:build rp2040
+
+package machine
+
+import (
+	"device/rp"
+)
+
+// Subsystems of the power-on state machine (PSM), as bits of the masks taken
+// by PSMReset and returned by PSMStatus.
+//
+// At power-on the PSM brings the subsystems out of reset in the order of
+// their bits, each depending on the ones before it: for example the clocks
+// block needs the oscillators, and the processors need everything else. A
+// mask passed to PSMReset should therefore also include the subsystems that
+// rely on the ones being reset.
+const (
+	PSMROSC      = 1 << 0
+	PSMXOSC      = 1 << 1
+	PSMClocks    = 1 << 2
+	PSMResets    = 1 << 3
+	PSMBusFabric = 1 << 4
+	PSMROM       = 1 << 5
+	PSMSRAM0     = 1 << 6
+	PSMSRAM1     = 1 << 7
+	PSMSRAM2     = 1 << 8
+	PSMSRAM3     = 1 << 9
+	PSMSRAM4     = 1 << 10
+	PSMSRAM5     = 1 << 11
+	PSMXIP       = 1 << 12
+	PSMVREG      = 1 << 13 // Voltage regulator and chip reset control.
+	PSMSIO       = 1 << 14
+	PSMProc0     = 1 << 15
+	PSMProc1     = 1 << 16
+
+	psmAll = 1<<17 - 1
+)
+
+// PSMReset puts the subsystems in mask through a reset with the power-on
+// state machine and waits for them to be running again.
+//
+// This is a low level operation: the caller must not reset anything it is
+// running from, such as the SRAM bank holding its stack or the processor
+// executing it, and must reconfigure the reset subsystems afterwards. It is
+// typically used to reset PROC1 or to bring blocks into a known state after
+// waking from dormant mode.
+func PSMReset(mask uint32) {
+	mask &= psmAll
+	rp.PSM.FRCE_OFF.SetBits(mask)
+	for rp.PSM.DONE.Get()&mask != 0 {
+	}
+	rp.PSM.FRCE_OFF.ClearBits(mask)
+	for rp.PSM.DONE.Get()&mask != mask {
+	}
+}
+
+// PSMStatus returns the subsystems which are out of reset and running, as a
+// mask of the PSM bits.
+func PSMStatus() uint32 {
+	return rp.PSM.DONE.Get() & psmAll
+}
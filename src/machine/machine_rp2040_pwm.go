@@ -6,12 +6,15 @@ import (
 	"device/rp"
 	"errors"
 	"math"
+	"math/bits"
 	"runtime/volatile"
 	"unsafe"
 )
 
 var (
-	ErrBadPeriod = errors.New("period outside valid range 8ns..268ms")
+	ErrBadPeriod         = errors.New("period outside valid range 8ns..268ms")
+	ErrBadClockDivider   = errors.New("pwm clock divider integer part must be non-zero and fractional part at most 15")
+	errPWMMeasureOverrun = errors.New("pwm measurement window extended by interrupts")
 )
 
 const (
@@ -91,6 +94,9 @@ func (pwm *pwmGroup) Channel(pin Pin) (channel uint8, err error) {
 	if pin > maxPWMPins || pwmGPIOToSlice(pin) != pwm.peripheral() {
 		return 3, ErrInvalidOutputPin
 	}
+	if pwm.usedByTicker() {
+		return 3, errPWMSliceTicker
+	}
 	pin.Configure(PinConfig{PinPWM})
 	return pwmGPIOToChannel(pin), nil
 }
@@ -123,6 +129,9 @@ func (pwm *pwmGroup) peripheral() uint8 {
 // by the bare minimum to reach the target period. It will also enable phase
 // correct to reach periods above 130ms.
 func (p *pwmGroup) SetPeriod(period uint64) error {
+	if p.usedByTicker() {
+		return errPWMSliceTicker
+	}
 	if period == 0 {
 		period = 1e5
 	}
@@ -134,12 +143,19 @@ func (p *pwmGroup) SetPeriod(period uint64) error {
 // The value returned here is hardware dependent. In general, it's best to treat
 // it as an opaque value that can be divided by some number and passed to Set
 // (see Set documentation for more information).
+//
+// Top is also the duty cycle resolution: Set accepts values from 0 to Top, so
+// the number of distinct duty cycles is Top+1. It depends on the frequency
+// set with Configure or SetPeriod, since a higher frequency leaves fewer
+// clk_sys cycles in each period, and must be read again after either call.
 func (p *pwmGroup) Top() uint32 {
 	return p.getWrap()
 }
 
 // Counter returns the current counter value of the timer in this PWM
-// peripheral. It may be useful for debugging.
+// peripheral, which tells how far into the period the PWM is. The counter
+// keeps running while it is read so the value is a snapshot, already stale by
+// the time Counter returns when the divider is small.
 func (p *pwmGroup) Counter() uint32 {
 	return (p.CTR.Get() & rp.PWM_CH0_CTR_CH0_CTR_Msk) >> rp.PWM_CH0_CTR_CH0_CTR_Pos
 }
@@ -172,12 +188,38 @@ func (p *pwmGroup) SetInverting(channel uint8, inverting bool) {
 //
 // pwm.Set(channel, 0) will set the output to low and pwm.Set(channel,
 // pwm.Top()) will set the output to high, assuming the output isn't inverted.
+//
+// The compare value is double buffered in hardware: a new value takes effect
+// when the counter wraps, so the period being output is never cut short or
+// stretched and Set can be called at any time without glitching the output.
+// See SetSynced to wait for the new value to take effect.
 func (p *pwmGroup) Set(channel uint8, value uint32) {
 	val := uint16(value)
 	channel &= 1
 	p.setChanLevel(channel, val)
 }
 
+// SetSynced updates the channel value like Set and busy-waits for the counter
+// to wrap, which is when the new value takes effect, for up to one period. A
+// subsequent Set is then guaranteed to produce at least one period at this
+// value, as needed for smooth LED fades or motor ramps updated faster than the
+// PWM period. It returns right away if the slice is not running.
+//
+// SetSynced uses the raw wrap interrupt flag of the slice, so it must not be
+// used on a slice driving a PWMTicker.
+func (p *pwmGroup) SetSynced(channel uint8, value uint32) {
+	p.Set(channel, value)
+	if !p.IsEnabled() {
+		return
+	}
+	// If the counter wraps between the Set above and clearing the flag, the
+	// value is already in effect and this waits for one more period.
+	mask := uint32(1) << p.peripheral()
+	rp.PWM.INTR.Set(mask)
+	for rp.PWM.INTR.Get()&mask == 0 {
+	}
+}
+
 // Get current level (last set by Set). Default value on initialization is 0.
 func (p *pwmGroup) Get(channel uint8) (value uint32) {
 	channel &= 1
@@ -190,9 +232,26 @@ func (p *pwmGroup) SetTop(top uint32) {
 }
 
 // SetCounter sets counter control register. Max value is 16bit (0xffff).
-// Useful for synchronising two different PWM peripherals.
+// Useful for synchronising two different PWM peripherals, or aligning the
+// phase of the output with an external event.
 func (p *pwmGroup) SetCounter(ctr uint32) {
-	p.CTR.Set(ctr)
+	p.CTR.ReplaceBits(ctr<<rp.PWM_CH0_CTR_CH0_CTR_Pos, rp.PWM_CH0_CTR_CH0_CTR_Msk, 0)
+}
+
+// SetClockDivider sets the 8.4 fixed point clock divider of this PWM peripheral
+// directly, so the counter runs at
+//
+//	f = f_sys / (intPart + fracPart/16)
+//
+// intPart must be non-zero and fracPart at most 15. SetPeriod overwrites the
+// divider, call SetTop afterwards to choose the period at the new counting
+// rate. This is useful to get exact rates, such as audio sample clocks.
+func (p *pwmGroup) SetClockDivider(intPart, fracPart uint8) error {
+	if intPart == 0 || fracPart > 15 {
+		return ErrBadClockDivider
+	}
+	p.setClockDiv(intPart, fracPart)
+	return nil
 }
 
 // Enable enables or disables PWM peripheral channels.
@@ -205,9 +264,171 @@ func (p *pwmGroup) IsEnabled() (enabled bool) {
 	return (p.CSR.Get()&rp.PWM_CH0_CSR_EN_Msk)>>rp.PWM_CH0_CSR_EN_Pos != 0
 }
 
+// SetEnablePin gates the outputs of this PWM peripheral with pin, typically
+// wired to the fault output of a motor driver. The outputs run while pin is at
+// its active level, high if activeHigh is set and low otherwise. When pin
+// leaves the active level the GPIOs configured as outputs of the peripheral
+// are forced low, or high for outputs configured with ConfigurePWMInverted,
+// and the peripheral is stopped. When pin is active again the outputs are
+// released and the peripheral is enabled again if it was running.
+//
+// The outputs are the GPIOs connected to the peripheral when SetEnablePin is
+// called; call it again after connecting more. Gating is done in software
+// from the pin change interrupt, so the outputs are forced after the
+// interrupt latency plus a few register writes. The response is delayed for
+// as long as interrupts are disabled or a higher priority interrupt runs. pin
+// is configured as an input pulled to its inactive level, so a disconnected
+// pin keeps the outputs off.
+//
+// Pass NoPin to remove the gating set previously. Outputs forced by it are
+// released and the peripheral is enabled again if it was running when they
+// were forced; it is otherwise left as is.
+func (p *pwmGroup) SetEnablePin(pin Pin, activeHigh bool) error {
+	slice := p.peripheral()
+	if prev := &pwmEnablePins[slice]; prev.set {
+		prev.pin.SetInterrupt(0, nil)
+		p.gate(true)
+		prev.set = false
+	}
+	if pin == NoPin {
+		return nil
+	}
+	var outputs uint32
+	for gpio := Pin(0); gpio < _NUMBANK0_GPIOS; gpio++ {
+		if pwmGPIOToSlice(gpio) == slice && gpio.getFunc() == fnPWM {
+			outputs |= 1 << gpio
+		}
+	}
+	mode := PinInputPulldown
+	if !activeHigh {
+		mode = PinInputPullup
+	}
+	pin.Configure(PinConfig{Mode: mode})
+	pwmEnablePins[slice] = pwmEnablePin{pin: pin, outputs: outputs}
+	err := pin.SetInterrupt(PinRising|PinFalling, func(pin Pin) {
+		p.gate(pin.Get() == activeHigh)
+	})
+	if err != nil {
+		return err
+	}
+	pwmEnablePins[slice].set = true
+	// Apply the current level, no edge may come.
+	p.gate(pin.Get() == activeHigh)
+	return nil
+}
+
+// Enable pins set with SetEnablePin, by PWM peripheral.
+var pwmEnablePins [8]pwmEnablePin
+
+type pwmEnablePin struct {
+	pin Pin
+	set bool
+	// Mask of the GPIOs connected to the peripheral.
+	outputs uint32
+	// gated is set while the outputs are forced, and running if the
+	// peripheral was enabled when they were.
+	gated, running bool
+}
+
+// gate forces the outputs recorded by SetEnablePin to their inactive level
+// and stops the peripheral if enabled is false, and undoes it otherwise.
+// Outputs are forced before the peripheral is stopped, since a stopped
+// peripheral holds the level it was driving. Outputs inverted at the pad are
+// forced high instead of low, which is how they are told apart when the
+// override is undone.
+func (pwm *pwmGroup) gate(enabled bool) {
+	state := &pwmEnablePins[pwm.peripheral()]
+	if state.gated != enabled {
+		return
+	}
+	if !enabled {
+		state.running = pwm.IsEnabled()
+	}
+	for outputs := state.outputs; outputs != 0; outputs &= outputs - 1 {
+		ctrl := Pin(bits.TrailingZeros32(outputs)).ioCtrl()
+		over := (ctrl.Get() & rp.IO_BANK0_GPIO0_CTRL_OUTOVER_Msk) >> rp.IO_BANK0_GPIO0_CTRL_OUTOVER_Pos
+		switch {
+		case !enabled && over == rp.IO_BANK0_GPIO0_CTRL_OUTOVER_NORMAL:
+			over = rp.IO_BANK0_GPIO0_CTRL_OUTOVER_LOW
+		case !enabled && over == rp.IO_BANK0_GPIO0_CTRL_OUTOVER_INVERT:
+			over = rp.IO_BANK0_GPIO0_CTRL_OUTOVER_HIGH
+		case enabled && over == rp.IO_BANK0_GPIO0_CTRL_OUTOVER_LOW:
+			over = rp.IO_BANK0_GPIO0_CTRL_OUTOVER_NORMAL
+		case enabled && over == rp.IO_BANK0_GPIO0_CTRL_OUTOVER_HIGH:
+			over = rp.IO_BANK0_GPIO0_CTRL_OUTOVER_INVERT
+		}
+		ctrl.ReplaceBits(over<<rp.IO_BANK0_GPIO0_CTRL_OUTOVER_Pos, rp.IO_BANK0_GPIO0_CTRL_OUTOVER_Msk, 0)
+	}
+	if !enabled || state.running {
+		pwm.enable(enabled)
+	}
+	state.gated = !enabled
+}
+
+// MeasureDutyCycle measures the fraction of time, from 0 to 1, during which
+// the signal on pin is high. pin must be the B channel of this peripheral, an
+// odd numbered GPIO, and is configured as its input.
+//
+// The counter of the peripheral is run in level mode, where it only counts
+// while B is high, for a window of 10ms at sysclk/100 (1.25MHz). The result
+// has a resolution of about 0.01%, but as the window is not a multiple of
+// the input period, it is off by up to the input period divided by 10ms: 1%
+// for a 1kHz input. Signals slower than 100Hz need several calls to average.
+//
+// The counter of the peripheral doesn't drive its outputs during the
+// measurement. The peripheral configuration and the function and pad settings
+// of pin are restored afterwards, so a pin which was not connected to the
+// PWM, for instance an input, doesn't end up driving the signal it measured.
+func (p *pwmGroup) MeasureDutyCycle(pin Pin) (float32, error) {
+	if pin > maxPWMPins || pwmGPIOToSlice(pin) != p.peripheral() || pwmGPIOToChannel(pin) != 1 {
+		return 0, ErrInvalidInputPin
+	}
+	const (
+		clkDiv   = 100
+		window   = 10_000 // us
+		maxCount = window * 125 * MHz / clkDiv / 1_000_000
+	)
+	csr, div, top := p.CSR.Get(), p.DIV.Get(), p.TOP.Get()
+	ioCtrl, padCtrl := pin.ioCtrl().Get(), pin.padCtrl().Get()
+	pin.Configure(PinConfig{PinPWM})
+	p.enable(false)
+	p.CSR.Set(rp.PWM_CH0_CSR_DIVMODE_LEVEL << rp.PWM_CH0_CSR_DIVMODE_Pos)
+	p.setClockDiv(clkDiv, 0)
+	p.setWrap(0xffff)
+	p.CTR.Set(0)
+
+	start := ticks()
+	p.enable(true)
+	// The window is measured rather than assumed, interrupts may extend it.
+	for ticks()-start < window {
+	}
+	p.enable(false)
+	elapsed := ticks() - start
+	high := p.CTR.Get() & rp.PWM_CH0_CTR_CH0_CTR_Msk
+
+	// Give the pin back before the slice may run again.
+	pin.ioCtrl().Set(ioCtrl)
+	pin.padCtrl().Set(padCtrl)
+	p.DIV.Set(div)
+	p.TOP.Set(top)
+	p.CSR.Set(csr)
+	total := uint64(maxCount) * elapsed / window
+	if total > 0xffff {
+		// The counter may have wrapped.
+		return 0, errPWMMeasureOverrun
+	}
+	if uint64(high) > total {
+		return 1, nil
+	}
+	return float32(high) / float32(total), nil
+}
+
 // Initialise a PWM with settings from a configuration object.
 // If start is true then PWM starts on initialization.
 func (pwm *pwmGroup) init(config PWMConfig, start bool) error {
+	if pwm.usedByTicker() {
+		return errPWMSliceTicker
+	}
 	// Not enable Phase correction
 	pwm.setPhaseCorrect(false)
 
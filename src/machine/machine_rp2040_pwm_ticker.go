@@ -0,0 +1,115 @@
+//go:build rp2040
+
+package machine
+
+import (
+	"device/rp"
+	"errors"
+	"runtime/interrupt"
+)
+
+var (
+	errPWMTickerFrequency = errors.New("pwm ticker frequency must be in 8..1000000Hz")
+	errNoPWMSlice         = errors.New("no unused pwm slice available")
+	errPWMSliceTicker     = errors.New("pwm slice in use by a PWMTicker")
+)
+
+// Callbacks called from the PWM wrap interrupt, by slice.
+var pwmWrapCallbacks [8]func()
+
+// PWMTicker calls a function periodically from the wrap interrupt of a PWM
+// slice. See NewPWMTicker.
+type PWMTicker struct {
+	pwm *pwmGroup
+}
+
+// NewPWMTicker starts calling callback hz times per second, using a PWM
+// slice as a timer. This leaves the alarms of the system timer free for the
+// runtime.
+//
+// The slice is one which is not enabled and has no GPIO connected to it, so
+// the ticker's waveform never reaches a pin. It is reserved until Stop:
+// Configure, SetPeriod and Channel return an error for it meanwhile.
+//
+// The period is counted in hardware so ticks do not drift, but each callback
+// runs after the interrupt latency, which varies by a few microseconds, more
+// when interrupts are disabled or other handlers run. The period is rounded
+// down to a whole number of divided clk_sys cycles, an error below 0.01% for
+// rates up to 10kHz.
+//
+// callback runs in interrupt context so it must be short, not block and not
+// allocate.
+func NewPWMTicker(hz uint32, callback func()) (*PWMTicker, error) {
+	if hz < 8 || hz > 1_000_000 {
+		return nil, errPWMTickerFrequency
+	}
+	var pwm *pwmGroup
+	state := interrupt.Disable()
+	for slice := uintptr(0); slice < 8; slice++ {
+		p := getPWMGroup(slice)
+		if !p.IsEnabled() && pwmWrapCallbacks[slice] == nil && !pwmSliceConnected(uint8(slice)) {
+			pwm = p
+			pwmWrapCallbacks[slice] = callback
+			break
+		}
+	}
+	interrupt.Restore(state)
+	if pwm == nil {
+		return nil, errNoPWMSlice
+	}
+
+	cycles := CPUFrequency() / hz
+	div := (cycles + 0xffff) / 0x10000
+	pwm.CSR.Set(0)
+	pwm.setClockDiv(uint8(div), 0)
+	pwm.setWrap(uint16(cycles/div - 1))
+	pwm.CTR.Set(0)
+
+	slice := pwm.peripheral()
+	rp.PWM.INTR.Set(1 << slice)
+	rp.PWM.INTE.SetBits(1 << slice)
+	interrupt.New(rp.IRQ_PWM_IRQ_WRAP, pwmHandleInterrupt).Enable()
+	pwm.enable(true)
+	return &PWMTicker{pwm: pwm}, nil
+}
+
+// Stop stops the ticker and releases its PWM slice. No callback is called
+// after Stop returns.
+func (t *PWMTicker) Stop() {
+	if t.pwm == nil {
+		return
+	}
+	slice := t.pwm.peripheral()
+	state := interrupt.Disable()
+	t.pwm.enable(false)
+	rp.PWM.INTE.ClearBits(1 << slice)
+	rp.PWM.INTR.Set(1 << slice)
+	pwmWrapCallbacks[slice] = nil
+	interrupt.Restore(state)
+	t.pwm = nil
+}
+
+// usedByTicker reports whether the slice is reserved by a PWMTicker.
+func (pwm *pwmGroup) usedByTicker() bool {
+	return pwmWrapCallbacks[pwm.peripheral()] != nil
+}
+
+// pwmSliceConnected reports whether a GPIO is connected to the PWM slice.
+func pwmSliceConnected(slice uint8) bool {
+	for pin := Pin(0); pin < _NUMBANK0_GPIOS; pin++ {
+		if pwmGPIOToSlice(pin) == slice && pin.getFunc() == fnPWM {
+			return true
+		}
+	}
+	return false
+}
+
+func pwmHandleInterrupt(intr interrupt.Interrupt) {
+	ints := rp.PWM.INTS.Get()
+	rp.PWM.INTR.Set(ints)
+	for slice := range pwmWrapCallbacks {
+		if ints&(1<<slice) != 0 && pwmWrapCallbacks[slice] != nil {
+			pwmWrapCallbacks[slice]()
+		}
+	}
+}
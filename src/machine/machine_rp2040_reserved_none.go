@@ -0,0 +1,7 @@
+//go:build rp2040 && !pico
+
+package machine
+
+// Pins wired to board functions, see Pin.IsReserved. None are known for this
+// board.
+const reservedPins = 0
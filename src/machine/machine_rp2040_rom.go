@@ -63,6 +63,15 @@ void *rom_func_lookup(uint32_t code) {
 	return rom_table_lookup(func_table, code);
 }
 
+uint8_t rom_version(void) {
+	return *(uint8_t *)0x13;
+}
+
+bool rom_magic_ok(void) {
+	uint8_t *magic = (uint8_t *)0x10;
+	return magic[0] == 'M' && magic[1] == 'u' && magic[2] == 0x01;
+}
+
 void reset_usb_boot(uint32_t usb_activity_gpio_pin_mask, uint32_t disable_interface_mask) {
 	rom_reset_usb_boot_fn func = (rom_reset_usb_boot_fn) rom_func_lookup(ROM_FUNC_RESET_USB_BOOT);
 	func(usb_activity_gpio_pin_mask, disable_interface_mask);
@@ -139,6 +148,29 @@ func enterBootloader() {
 	C.reset_usb_boot(0, 0)
 }
 
+// BootromVersion returns the version of the bootrom, which identifies the
+// chip revision: 1 for B0, 2 for B1 and 3 for B2. Later versions fix bugs in
+// the floating point routines and the USB bootloader, and add functions to the
+// function table, which BootromFunc reports as missing on earlier ones.
+func BootromVersion() uint8 {
+	return uint8(C.rom_version())
+}
+
+// BootromFunc returns the address of the bootrom function identified by
+// code, or 0 if the bootrom does not provide it. The code is made of the two
+// characters of the function name as found in the RP2040 datasheet, the
+// first in the low byte: for example reset_usb_boot is 'U' | 'B'<<8.
+//
+// Calling the function is up to the caller and requires matching its C
+// signature exactly. Flash functions must not be called while executing from
+// flash.
+func BootromFunc(code uint16) uintptr {
+	if C.rom_magic_ok() == 0 {
+		return 0
+	}
+	return uintptr(C.rom_func_lookup(C.uint32_t(code)))
+}
+
 // Flash related code
 const memoryStart = C.XIP_BASE // memory start for purpose of erase
 
@@ -41,6 +41,17 @@ var (
 	}
 )
 
+// External clkRTC source set by SetRTCClockInput, zero frequency if none.
+var (
+	rtcClockInputAuxsrc uint32
+	rtcClockInputFreq   uint32
+)
+
+var (
+	errRTCClockInputPin  = errors.New("RTC clock input must be GPIO20 (GPIN0) or GPIO22 (GPIN1)")
+	errRTCClockInputFreq = errors.New("RTC clock input frequency out of range")
+)
+
 var (
 	ErrRtcDelayTooSmall = errors.New("RTC interrupt deplay is too small, shall be at least 1 second")
 	ErrRtcDelayTooLarge = errors.New("RTC interrupt deplay is too large, shall be no more than 1 day")
@@ -95,6 +106,64 @@ func toAlarmTime(delay uint32) rtcTime {
 	return result
 }
 
+// SetRTCClockInput runs the real time clock from an external clock of freq Hz,
+// at most 65536Hz, fed into pin, which must be GPIO20 or GPIO22. This is
+// typically a 32.768kHz oscillator. The RTC is started if not running yet.
+//
+// By default the RTC clock is derived from the crystal oscillator, which
+// stops in dormant mode like every other internal clock, so the RTC does not
+// count the time spent in SleepUntil. An external clock keeps running while
+// the chip is dormant, which lets TimeSinceBoot account for the time slept.
+//
+// Call it before SetInterrupt: changing the clock of a running RTC does not
+// update its divider, so it would run at the wrong rate.
+func SetRTCClockInput(pin Pin, freq uint32) error {
+	var auxsrc uint32
+	switch pin {
+	case GPIO20:
+		auxsrc = rp.CLOCKS_CLK_RTC_CTRL_AUXSRC_CLKSRC_GPIN0
+	case GPIO22:
+		auxsrc = rp.CLOCKS_CLK_RTC_CTRL_AUXSRC_CLKSRC_GPIN1
+	default:
+		return errRTCClockInputPin
+	}
+	if freq == 0 || freq-1 > rp.RTC_CLKDIV_M1_CLKDIV_M1_Msk {
+		return errRTCClockInputFreq
+	}
+	pin.setFunc(fnGPCK)
+	rtcClockInputAuxsrc = auxsrc
+	rtcClockInputFreq = freq
+	clocks.configureRTC(0, 0)
+	if !RTC.isActive() {
+		RTC.setDivider()
+		RTC.setTime(rtcEpoch)
+	}
+	return nil
+}
+
+// seconds returns the current RTC time in seconds since rtcEpoch, which also
+// works for times set from other dates, as long as only differences are used.
+func (rtc *rtcType) seconds() int64 {
+	// RTC_0 must be read first, it latches RTC_1.
+	r0 := rtc.RTC_0.Get()
+	r1 := rtc.RTC_1.Get()
+	year := int64((r1 & rp.RTC_RTC_1_YEAR_Msk) >> rp.RTC_RTC_1_YEAR_Pos)
+	month := int64((r1 & rp.RTC_RTC_1_MONTH_Msk) >> rp.RTC_RTC_1_MONTH_Pos)
+	dom := int64((r1 & rp.RTC_RTC_1_DAY_Msk) >> rp.RTC_RTC_1_DAY_Pos)
+	h := int64((r0 & rp.RTC_RTC_0_HOUR_Msk) >> rp.RTC_RTC_0_HOUR_Pos)
+	m := int64((r0 & rp.RTC_RTC_0_MIN_Msk) >> rp.RTC_RTC_0_MIN_Pos)
+	s := int64((r0 & rp.RTC_RTC_0_SEC_Msk) >> rp.RTC_RTC_0_SEC_Pos)
+
+	// Days since 1970-01-01 in the proleptic Gregorian calendar, counting
+	// years from March so the leap day comes last.
+	if month <= 2 {
+		year--
+		month += 12
+	}
+	days := 365*year + year/4 - year/100 + year/400 + (153*(month-3)+2)/5 + dom - 719469
+	return days*day + h*hour + m*minute + s
+}
+
 func (rtc *rtcType) setDivider() {
 	// Get clk_rtc freq and make sure it is running
 	rtcFreq := configuredFreq[clkRTC]
@@ -5,10 +5,16 @@ package machine
 import (
 	"device/rp"
 	"errors"
+	"runtime/volatile"
 	"unsafe"
 )
 
-// SPI on the RP2040
+// SPI on the RP2040. SPI0 and SPI1 are the only instances of the two SPI
+// peripherals, drivers should share them instead of declaring their own.
+//
+// When Configure is called without pins, SPI0 uses the board's SPI0_SCK_PIN,
+// SPI0_SDO_PIN and SPI0_SDI_PIN, and SPI1 the SPI1 ones. On the Raspberry Pi
+// Pico these are GPIO18/GPIO19/GPIO16 and GPIO10/GPIO11/GPIO12 respectively.
 var (
 	SPI0  = &_SPI0
 	_SPI0 = SPI{
@@ -33,6 +39,8 @@ type SPIConfig struct {
 	SDO Pin
 	// RX or Serial Data In (MISO if rp2040 is master)
 	SDI Pin
+	// Chip select input. Only used in peripheral mode, see ConfigureAsSlave.
+	CS Pin
 }
 
 var (
@@ -42,10 +50,19 @@ var (
 	errSPIInvalidSDI   = errors.New("invalid SPI SDI pin")
 	errSPIInvalidSDO   = errors.New("invalid SPI SDO pin")
 	errSPIInvalidSCK   = errors.New("invalid SPI SCK pin")
+	errSPIInvalidCS    = errors.New("invalid SPI CS pin")
+	ErrSPINotSlave     = errors.New("SPI not configured as slave")
 )
 
 type SPI struct {
 	Bus *rp.SPI0_Type
+	// DMA channel used for writes. nil means the channel statically reserved
+	// for this bus is used.
+	dmaChannel *DMAChannel
+	// Peripheral mode state, see ConfigureAsSlave.
+	cs    Pin
+	rxBuf []byte
+	rxN   volatile.Register32
 }
 
 // Tx handles read/write operation for SPI interface. Since SPI is a syncronous write/read
@@ -103,19 +120,39 @@ func (spi SPI) Transfer(w byte) (byte, error) {
 	return uint8(spi.Bus.SSPDR.Get()), nil
 }
 
+// SetDMAChannel sets the DMA channel used for write-only transfers. The channel
+// should have been claimed with ClaimDMAChannel and must not be used by
+// anything else while the SPI bus is in use.
+//
+// By default (or when ch is nil) SPI0 and SPI1 each use a DMA channel that is
+// reserved for them at startup and never handed out by ClaimDMAChannel.
+func (spi *SPI) SetDMAChannel(ch *DMAChannel) {
+	spi.dmaChannel = ch
+}
+
 func (spi SPI) SetBaudRate(br uint32) error {
+	prescale, postdiv, err := spiBaudDivisors(br)
+	if err != nil {
+		return err
+	}
+	spi.setBaudDivisors(prescale, postdiv)
+	return nil
+}
+
+// spiBaudDivisors returns the prescale and post-divide values giving the
+// highest SPI clock frequency not above br, or ErrSPIBaud if there is none.
+func spiBaudDivisors(br uint32) (prescale, postdiv uint32, err error) {
 	const freqin uint32 = 125 * MHz
 	const maxBaud uint32 = 66.5 * MHz // max output frequency is 66.5MHz on rp2040. see Note page 527.
 	// Find smallest prescale value which puts output frequency in range of
 	// post-divide. Prescale is an even number from 2 to 254 inclusive.
-	var prescale, postdiv uint32
 	for prescale = 2; prescale < 255; prescale += 2 {
 		if freqin < (prescale+2)*256*br {
 			break
 		}
 	}
 	if prescale > 254 || br > maxBaud {
-		return ErrSPIBaud
+		return 0, 0, ErrSPIBaud
 	}
 	// Find largest post-divide which makes output <= baudrate. Post-divide is
 	// an integer in the range 1 to 256 inclusive.
@@ -124,8 +161,34 @@ func (spi SPI) SetBaudRate(br uint32) error {
 			break
 		}
 	}
+	return prescale, postdiv, nil
+}
+
+// setBaudDivisors programs the clock divisors returned by spiBaudDivisors.
+func (spi SPI) setBaudDivisors(prescale, postdiv uint32) {
 	spi.Bus.SSPCPSR.Set(prescale)
 	spi.Bus.SSPCR0.ReplaceBits((postdiv-1)<<rp.SPI0_SSPCR0_SCR_Pos, rp.SPI0_SSPCR0_SCR_Msk, 0)
+}
+
+// SetFrequency changes the SPI clock frequency without reconfiguring pins or
+// mode, for buses shared by devices with different speeds. It waits for any
+// transfer in progress to complete and must be called while CS is deasserted.
+// If hz can't be reached, ErrSPIBaud is returned and the bus is left
+// untouched. The bus is only enabled again if it was enabled before.
+func (spi SPI) SetFrequency(hz uint32) error {
+	prescale, postdiv, err := spiBaudDivisors(hz)
+	if err != nil {
+		return err
+	}
+	for spi.isBusy() {
+	}
+	// The prescaler must not change while the SSP is enabled.
+	enabled := spi.Bus.SSPCR1.HasBits(rp.SPI0_SSPCR1_SSE)
+	spi.Bus.SSPCR1.ClearBits(rp.SPI0_SSPCR1_SSE)
+	spi.setBaudDivisors(prescale, postdiv)
+	if enabled {
+		spi.Bus.SSPCR1.SetBits(rp.SPI0_SSPCR1_SSE)
+	}
 	return nil
 }
 
@@ -136,6 +199,72 @@ func (spi SPI) GetBaudRate() uint32 {
 	return freqin / (prescale * postdiv)
 }
 
+// Config returns the configuration currently programmed in the SPI
+// peripheral, decoded from its registers: the actual frequency, which may
+// be below the one requested, the mode and the pins connected to the bus.
+// Pins not connected are NoPin. LSBFirst is always false.
+func (spi SPI) Config() SPIConfig {
+	cr0 := spi.Bus.SSPCR0.Get()
+	config := SPIConfig{
+		Frequency: spi.GetBaudRate(),
+		Mode: uint8((cr0&rp.SPI0_SSPCR0_SPO_Msk)>>rp.SPI0_SSPCR0_SPO_Pos<<1 |
+			(cr0&rp.SPI0_SSPCR0_SPH_Msk)>>rp.SPI0_SSPCR0_SPH_Pos),
+		SCK: NoPin,
+		SDO: NoPin,
+		SDI: NoPin,
+		CS:  NoPin,
+	}
+	bus := uint8(0)
+	if spi.Bus == rp.SPI1 {
+		bus = 1
+	}
+	for p := Pin(0); p < _NUMBANK0_GPIOS; p++ {
+		// SPI pins come in groups of 4 alternating between SPI0 and SPI1.
+		if p.getFunc() != fnSPI || uint8(p>>3)&1 != bus {
+			continue
+		}
+		switch p % 4 {
+		case 0:
+			config.SDI = p
+		case 1:
+			config.CS = p
+		case 2:
+			config.SCK = p
+		case 3:
+			config.SDO = p
+		}
+	}
+	return config
+}
+
+// SPIDevice is a device on a SPI bus selected with its chip select pin. It
+// implements BusReadWriter so drivers written for I2C style register access
+// also work over SPI.
+type SPIDevice struct {
+	Bus *SPI
+	// CS is the active low chip select pin of the device. It must be
+	// configured as an output and driven high beforehand.
+	CS Pin
+}
+
+// Tx selects the device, writes w and then reads len(r) bytes while sending
+// zeros, and deselects the device. addr is ignored, the device is selected
+// with CS.
+func (d SPIDevice) Tx(addr uint16, w, r []byte) error {
+	d.CS.Low()
+	var err error
+	if len(w) > 0 {
+		err = d.Bus.Tx(w, nil)
+	}
+	if err == nil && len(r) > 0 {
+		err = d.Bus.Tx(nil, r)
+	}
+	d.CS.High()
+	return err
+}
+
+var _ BusReadWriter = SPIDevice{}
+
 // Configure is intended to setup/initialize the SPI interface.
 // Default baudrate of 4MHz is used if Frequency == 0. Default
 // word length (data bits) is 8.
@@ -167,6 +296,24 @@ func (spi SPI) Configure(config SPIConfig) error {
 			config.SDI = SPI1_SDI_PIN
 		}
 	}
+	if err := spi.checkPins(config, true); err != nil {
+		return err
+	}
+
+	if config.Frequency == 0 {
+		config.Frequency = defaultBaud
+	}
+	// SPI pin configuration
+	config.SCK.setFunc(fnSPI)
+	config.SDO.setFunc(fnSPI)
+	config.SDI.setFunc(fnSPI)
+
+	return spi.initSPI(config)
+}
+
+// checkPins returns an error if the configured pins can't be used by the bus.
+// SDO is only checked if checkSDO is set.
+func (spi SPI) checkPins(config SPIConfig, checkSDO bool) error {
 	var okSDI, okSDO, okSCK bool
 	switch spi.Bus {
 	case rp.SPI0:
@@ -182,21 +329,12 @@ func (spi SPI) Configure(config SPIConfig) error {
 	switch {
 	case !okSDI:
 		return errSPIInvalidSDI
-	case !okSDO:
+	case checkSDO && !okSDO:
 		return errSPIInvalidSDO
 	case !okSCK:
 		return errSPIInvalidSCK
 	}
-
-	if config.Frequency == 0 {
-		config.Frequency = defaultBaud
-	}
-	// SPI pin configuration
-	config.SCK.setFunc(fnSPI)
-	config.SDO.setFunc(fnSPI)
-	config.SDI.setFunc(fnSPI)
-
-	return spi.initSPI(config)
+	return nil
 }
 
 func (spi SPI) initSPI(config SPIConfig) (err error) {
@@ -295,14 +433,19 @@ func (spi SPI) tx(tx []byte) error {
 		return nil
 	}
 
-	// Pick the DMA channel reserved for this SPI peripheral.
-	var ch *dmaChannel
+	// Pick the DMA channel set with SetDMAChannel, or else the one reserved
+	// for this SPI peripheral.
+	ch := spi.dmaChannel
 	var dreq uint32
 	if spi.Bus == rp.SPI0 {
-		ch = &dmaChannels[spi0DMAChannel]
+		if ch == nil {
+			ch = &dmaChannels[spi0DMAChannel]
+		}
 		dreq = 16 // DREQ_SPI0_TX
 	} else { // SPI1
-		ch = &dmaChannels[spi1DMAChannel]
+		if ch == nil {
+			ch = &dmaChannels[spi1DMAChannel]
+		}
 		dreq = 18 // DREQ_SPI1_TX
 	}
 
@@ -0,0 +1,138 @@
+//go:build rp2040
+
+package machine
+
+import (
+	"device/rp"
+	"runtime/interrupt"
+)
+
+// ConfigureAsSlave sets up the SPI bus as a peripheral (slave) responding to
+// an external controller. SCK, SDI (data from the controller) and CS are
+// inputs. config.Frequency is ignored since the controller drives the clock,
+// but it must not exceed 1/12 of the peripheral clock.
+//
+// CS must be the hardware chip select pin of the bus:
+//
+//	SPI0 CS: 1, 5, 17, 21
+//	SPI1 CS: 9, 13, 25, 29
+//
+// Frame format constraints of the PL022: with CPHA=0 (Mode 0 and 2) the
+// controller must deassert CS between every byte. Use Mode 1 or 3 to receive
+// several bytes in one CS assertion. Words are 8 bits, MSB first.
+//
+// The peripheral only receives data with Listen and never drives SDO. SDO
+// may be left unset, 0 or NoPin, so no pin is taken for it; GPIO0 is not an
+// SDO pin of either bus. If it is set, it must be an SDO pin of the bus and is
+// connected to it, in its output disabled state.
+//
+// Unlike Configure, no pins are picked by default: SCK, SDI and CS must all be
+// set.
+func (spi *SPI) ConfigureAsSlave(config SPIConfig) error {
+	if config.LSBFirst {
+		return ErrLSBNotSupported
+	}
+	useSDO := config.SDO != 0 && config.SDO != NoPin
+	if err := spi.checkPins(config, useSDO); err != nil {
+		return err
+	}
+	var okCS bool
+	switch spi.Bus {
+	case rp.SPI0:
+		okCS = config.CS == 1 || config.CS == 5 || config.CS == 17 || config.CS == 21
+	case rp.SPI1:
+		okCS = config.CS == 9 || config.CS == 13 || config.CS == 25 || config.CS == 29
+	}
+	if !okCS {
+		return errSPIInvalidCS
+	}
+	config.SCK.setFunc(fnSPI)
+	if useSDO {
+		config.SDO.setFunc(fnSPI)
+	}
+	config.SDI.setFunc(fnSPI)
+	config.CS.setFunc(fnSPI)
+	spi.cs = config.CS
+
+	spi.reset()
+	// Smallest prescale; the SSP clock must be at least 12 times SCK in
+	// peripheral mode.
+	spi.Bus.SSPCPSR.Set(2)
+	spi.setFormat(config.Mode, rp.XIP_SSI_CTRLR0_SPI_FRF_STD)
+	// Peripheral mode with output disabled.
+	spi.Bus.SSPCR1.Set(rp.SPI0_SSPCR1_MS | rp.SPI0_SSPCR1_SOD)
+	spi.Bus.SSPCR1.SetBits(rp.SPI0_SSPCR1_SSE)
+
+	switch spi.Bus {
+	case rp.SPI0:
+		interrupt.New(rp.IRQ_SPI0_IRQ, spi0HandleInterrupt).Enable()
+	case rp.SPI1:
+		interrupt.New(rp.IRQ_SPI1_IRQ, spi1HandleInterrupt).Enable()
+	}
+	return nil
+}
+
+// Listen waits for the controller to send data and stores it in buf. It
+// returns once buf is full or the controller deasserts CS after sending at
+// least one byte. Bytes sent beyond len(buf) remain in the receive FIFO and
+// are returned by the next call.
+//
+// The receive FIFO is drained from the SPI interrupt so other goroutines can
+// run while waiting.
+func (spi *SPI) Listen(buf []byte) (n int, err error) {
+	if !spi.Bus.SSPCR1.HasBits(rp.SPI0_SSPCR1_MS) {
+		return 0, ErrSPINotSlave
+	}
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	spi.rxBuf = buf
+	spi.rxN.Set(0)
+	// RX fires at half full FIFO, RT when data sits in the FIFO while the
+	// bus is idle.
+	spi.Bus.SSPIMSC.Set(rp.SPI0_SSPIMSC_RXIM | rp.SPI0_SSPIMSC_RTIM)
+	for {
+		n = int(spi.rxN.Get())
+		if n == len(buf) {
+			break
+		}
+		if spi.cs.get() && (n > 0 || spi.isReadable()) {
+			// CS deasserted, transfer finished.
+			break
+		}
+		gosched()
+	}
+	spi.Bus.SSPIMSC.Set(0)
+	n = int(spi.rxN.Get())
+	// Pick up data below the FIFO interrupt threshold.
+	for n < len(buf) && spi.isReadable() {
+		buf[n] = uint8(spi.Bus.SSPDR.Get())
+		n++
+	}
+	spi.rxBuf = nil
+	return n, nil
+}
+
+func spi0HandleInterrupt(intr interrupt.Interrupt) {
+	SPI0.handleInterrupt()
+}
+
+func spi1HandleInterrupt(intr interrupt.Interrupt) {
+	SPI1.handleInterrupt()
+}
+
+// handleInterrupt drains the receive FIFO into the buffer passed to Listen.
+func (spi *SPI) handleInterrupt() {
+	n := spi.rxN.Get()
+	for int(n) < len(spi.rxBuf) && spi.isReadable() {
+		spi.rxBuf[n] = uint8(spi.Bus.SSPDR.Get())
+		n++
+	}
+	spi.rxN.Set(n)
+	if int(n) == len(spi.rxBuf) {
+		// Buffer full, leave the rest in the FIFO for the next Listen.
+		spi.Bus.SSPIMSC.Set(0)
+	}
+	// Clear receive timeout.
+	spi.Bus.SSPICR.Set(rp.SPI0_SSPICR_RTIC)
+}
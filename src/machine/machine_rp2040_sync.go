@@ -4,6 +4,10 @@ package machine
 
 import (
 	"device/rp"
+	"errors"
+	"runtime/interrupt"
+	"runtime/volatile"
+	"unsafe"
 )
 
 // machine_rp2040_sync.go contains interrupt and
@@ -14,9 +18,14 @@ const (
 	// Number of spin locks available
 	_NUMSPINLOCKS = 32
 	// Number of interrupt handlers available
-	_NUMIRQ               = 32
+	_NUMIRQ = 32
+	// Spin lock reserved for interrupt setup shared between cores. It is
+	// never handed out by ClaimSpinlock.
 	_PICO_SPINLOCK_ID_IRQ = 9
-	_NUMBANK0_GPIOS       = 30
+	// Spin lock reserved for the latched read of the timer by Ticks, as in
+	// the Pico SDK. It is never handed out by ClaimSpinlock either.
+	_PICO_SPINLOCK_ID_TIMER = 10
+	_NUMBANK0_GPIOS         = 30
 )
 
 // Clears interrupt flag on a pin
@@ -36,6 +45,47 @@ func (p Pin) setInterrupt(change PinChange, enabled bool) {
 	}
 }
 
+// GPIOInterruptMask holds the GPIO interrupt enables of a core, 4 event bits
+// per pin as in the INTE registers of IO_BANK0.
+type GPIOInterruptMask [4]uint32
+
+// DisableGPIOInterrupts disables all GPIO interrupts of the calling core and
+// returns the previous enables, to be passed to RestoreGPIOInterrupts. Other
+// interrupts are left untouched, which makes this cheaper for critical
+// sections that only race with pin change callbacks.
+//
+// Edges latched while disabled are kept and serviced once restored.
+func DisableGPIOInterrupts() GPIOInterruptMask {
+	base := coreIRQCtrl()
+	var mask GPIOInterruptMask
+	state := interrupt.Disable()
+	for i := range base.intE {
+		mask[i] = base.intE[i].Get()
+		base.intE[i].Set(0)
+	}
+	interrupt.Restore(state)
+	return mask
+}
+
+// RestoreGPIOInterrupts restores the GPIO interrupt enables of the calling
+// core saved by DisableGPIOInterrupts. It must be called from the same core.
+func RestoreGPIOInterrupts(mask GPIOInterruptMask) {
+	base := coreIRQCtrl()
+	state := interrupt.Disable()
+	for i := range base.intE {
+		base.intE[i].Set(mask[i])
+	}
+	interrupt.Restore(state)
+}
+
+// coreIRQCtrl returns the GPIO interrupt controls of the calling core.
+func coreIRQCtrl() *irqCtrl {
+	if CurrentCore() == 1 {
+		return &ioBank0.proc1IRQctrl
+	}
+	return &ioBank0.proc0IRQctrl
+}
+
 // ctrlSetInterrupt acknowledges any pending interrupt and enables or disables
 // the interrupt for a given IRQ control bank (IOBANK, DormantIRQ, QSPI).
 //
@@ -70,3 +120,109 @@ func irqSetMask(mask uint32, enabled bool) {
 		rp.PPB.NVIC_ICER.Set(mask)
 	}
 }
+
+// SaveIRQState returns the interrupts enabled in the NVIC of the executing
+// core, bit n standing for IRQ n. Each core has its own NVIC, so the state of
+// the other core is not included.
+//
+// Together with RestoreIRQState it lets a critical section mask only the
+// interrupts that would disturb it, instead of all of them like
+// interrupt.Disable:
+//
+//	state := machine.SaveIRQState()
+//	machine.RestoreIRQState(state &^ (1 << rp.IRQ_USBCTRL_IRQ))
+//	// ...
+//	machine.RestoreIRQState(state)
+func SaveIRQState() uint32 {
+	return rp.PPB.NVIC_ISER.Get()
+}
+
+// RestoreIRQState enables exactly the interrupts in mask, as returned by
+// SaveIRQState, on the executing core and disables the others. Pending
+// interrupts are not cleared, so the ones raised while they were disabled are
+// handled as soon as they are enabled again.
+func RestoreIRQState(mask uint32) {
+	rp.PPB.NVIC_ICER.Set(^mask)
+	rp.PPB.NVIC_ISER.Set(mask)
+}
+
+// Spinlock is one of the hardware spin locks of the SIO block. Spin locks
+// provide mutual exclusion between the two cores; they do not disable
+// interrupts so a lock must not be taken from an interrupt handler that may
+// preempt its holder on the same core.
+type Spinlock struct {
+	id uint8
+}
+
+var spinlocks = (*[_NUMSPINLOCKS]volatile.Register32)(unsafe.Pointer(&rp.SIO.SPINLOCK0))
+
+// Bitmask of claimed spin locks. The locks used for interrupt setup and the
+// timer are reserved so user code cannot deadlock against them.
+var spinlocksClaimed uint32 = 1<<_PICO_SPINLOCK_ID_IRQ | 1<<_PICO_SPINLOCK_ID_TIMER
+
+var errNoSpinlock = errors.New("no spin lock available")
+
+// ClaimSpinlock claims an unused hardware spin lock. The lock can be returned
+// to the pool with Unclaim once it is no longer in use.
+func ClaimSpinlock() (Spinlock, error) {
+	// The claim mask is shared by both cores.
+	state := irqLock()
+	defer irqUnlock(state)
+	for i := uint8(0); i < _NUMSPINLOCKS; i++ {
+		if spinlocksClaimed&(1<<i) == 0 {
+			spinlocksClaimed |= 1 << i
+			return Spinlock{id: i}, nil
+		}
+	}
+	return Spinlock{}, errNoSpinlock
+}
+
+// Unclaim returns the spin lock to the pool of locks available to
+// ClaimSpinlock. The lock must not be held.
+func (s Spinlock) Unclaim() {
+	if s.id == _PICO_SPINLOCK_ID_IRQ || s.id == _PICO_SPINLOCK_ID_TIMER {
+		return
+	}
+	state := irqLock()
+	spinlocksClaimed &^= 1 << s.id
+	irqUnlock(state)
+}
+
+// ID returns the number of the spin lock, in the range 0..31.
+func (s Spinlock) ID() uint8 {
+	return s.id
+}
+
+// Lock spins until the lock is acquired.
+func (s Spinlock) Lock() {
+	// Reading the register claims the lock, a value of zero means it was
+	// already held.
+	for spinlocks[s.id].Get() == 0 {
+	}
+}
+
+// TryLock attempts to acquire the lock without spinning and reports whether it
+// succeeded.
+func (s Spinlock) TryLock() bool {
+	return spinlocks[s.id].Get() != 0
+}
+
+// Unlock releases the lock.
+func (s Spinlock) Unlock() {
+	spinlocks[s.id].Set(0)
+}
+
+// irqLock disables interrupts on the calling core and takes the spin lock
+// reserved for interrupt setup, which guards state shared by both cores such
+// as the interrupt callback tables. It must be released with irqUnlock.
+func irqLock() interrupt.State {
+	state := interrupt.Disable()
+	Spinlock{id: _PICO_SPINLOCK_ID_IRQ}.Lock()
+	return state
+}
+
+// irqUnlock releases the lock taken by irqLock.
+func irqUnlock(state interrupt.State) {
+	Spinlock{id: _PICO_SPINLOCK_ID_IRQ}.Unlock()
+	interrupt.Restore(state)
+}
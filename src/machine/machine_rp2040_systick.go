@@ -0,0 +1,55 @@
+//go:build rp2040
+
+package machine
+
+import (
+	"device/arm"
+	"errors"
+)
+
+var (
+	errSysTickReload    = errors.New("SysTick reload value does not fit in 24 bits")
+	errSysTickNoHandler = errors.New("SysTick handler not built, build with -tags rp2040_systick")
+)
+
+// Callbacks of the SysTick exception, one per core.
+var sysTickCallbacks [2]func()
+
+// ConfigureSysTick starts the SysTick timer of the calling core so callback
+// is called from the SysTick exception every reload+1 cycles, or stops it if
+// reload is 0 or callback is nil. reload must fit in 24 bits.
+//
+// SysTick is part of each Cortex-M0+ core and is independent of the 1MHz
+// system timer used by the runtime and Ticks. It runs from the core clock,
+// clk_sys, so the period is (reload+1)/CPUFrequency(): for a 1ms tick at
+// 125MHz, reload is 124999. The period changes with clk_sys, for example while
+// SleepUntil runs the chip from the crystal oscillator.
+//
+// The callback runs in exception context, the usual restrictions of interrupt
+// handlers apply.
+//
+// SysTick is an exception, which can't be set up with interrupt.New, so the
+// callback is called from a SysTick_Handler defined by the machine package.
+// It is only built with the rp2040_systick build tag, which leaves programs
+// free to define their own SysTick_Handler otherwise; without the tag
+// ConfigureSysTick returns an error.
+func ConfigureSysTick(reload uint32, callback func()) error {
+	if !sysTickHandlerBuilt {
+		return errSysTickNoHandler
+	}
+	if reload > arm.SYST_RVR_RELOAD_Msk {
+		return errSysTickReload
+	}
+	core := CurrentCore()
+	arm.SYST.SYST_CSR.ClearBits(arm.SYST_CSR_TICKINT | arm.SYST_CSR_ENABLE)
+	if reload == 0 || callback == nil {
+		sysTickCallbacks[core] = nil
+		return nil
+	}
+	sysTickCallbacks[core] = callback
+	arm.SYST.SYST_RVR.Set(reload)
+	// Any write clears the current value, so the first period is complete.
+	arm.SYST.SYST_CVR.Set(0)
+	arm.SYST.SYST_CSR.SetBits(arm.SYST_CSR_CLKSOURCE | arm.SYST_CSR_TICKINT | arm.SYST_CSR_ENABLE)
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build rp2040 && rp2040_systick
+
+package machine
+
+// The SysTick_Handler below is built, see ConfigureSysTick.
+const sysTickHandlerBuilt = true
+
+//export SysTick_Handler
+func sysTickHandler() {
+	if callback := sysTickCallbacks[CurrentCore()]; callback != nil {
+		callback()
+	}
+}
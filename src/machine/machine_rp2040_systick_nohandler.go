@@ -0,0 +1,7 @@
+//go:build rp2040 && !rp2040_systick
+
+package machine
+
+// No SysTick_Handler is built, programs may define their own. See
+// ConfigureSysTick.
+const sysTickHandlerBuilt = false
@@ -7,6 +7,7 @@ import (
 	"device/rp"
 	"runtime/interrupt"
 	"runtime/volatile"
+	"time"
 	"unsafe"
 )
 
@@ -60,6 +61,52 @@ func (tmr *timerType) timeElapsed() (us uint64) {
 	return uint64(hi)<<32 | uint64(lo)
 }
 
+// Ticks returns the value of the 64-bit microsecond timer, which counts from
+// power up and does not wrap in practice. It is meant for benchmarking and
+// profiling: the value is monotonic and read without tearing, so it can be
+// compared across cores.
+//
+// The value is read with the latched sequence, TIMELR then TIMEHR. The latch
+// is shared by both cores and by interrupt handlers, so the read is done with
+// interrupts disabled under a spin lock reserved for it, which costs a few
+// hundred nanoseconds. The lock guards nothing else, so Ticks never waits for
+// interrupt setup on the other core.
+func Ticks() uint64 {
+	lock := Spinlock{id: _PICO_SPINLOCK_ID_TIMER}
+	state := interrupt.Disable()
+	lock.Lock()
+	lo := timer.timeLR.Get()
+	hi := timer.timeHR.Get()
+	lock.Unlock()
+	interrupt.Restore(state)
+	return uint64(hi)<<32 | uint64(lo)
+}
+
+// TicksToMicroseconds converts a difference of values returned by Ticks to
+// microseconds. The timer runs at 1MHz so this is the identity, but code using
+// it keeps working should the tick rate change.
+func TicksToMicroseconds(t uint64) uint64 {
+	return t
+}
+
+// Time spent in dormant mode by SleepUntil as measured by the RTC, in
+// microseconds.
+var dormantMicros uint64
+
+// TimeSinceBoot returns the time elapsed since power up, including the time
+// spent in dormant mode with SleepUntil, during which the system timer stops.
+// This needs the RTC to keep running while dormant, which requires it to be
+// clocked from an external source with SetRTCClockInput; otherwise the time
+// slept is not counted, as with Ticks.
+//
+// The RTC only counts whole seconds, so each sleep adds an error of up to a
+// second: TimeSinceBoot has microsecond resolution between sleeps, but only
+// one second resolution across them. Prefer Ticks to measure short intervals
+// which don't span a sleep.
+func TimeSinceBoot() time.Duration {
+	return time.Duration(Ticks()+dormantMicros) * time.Microsecond
+}
+
 // lightSleep will put the processor into a sleep state a short period
 // (up to approx 72mins per RP2040 datasheet, 4.6.3. Alarms).
 //
@@ -103,3 +150,27 @@ func (tmr *timerType) lightSleep(us uint64) {
 	// Disable interrupt
 	intr.Disable()
 }
+
+// Sleep blocks the calling core for d. Delays of 10us and more are spent
+// asleep waiting for a timer alarm, which saves power compared to spinning;
+// shorter ones are busy-waited. Unlike time.Sleep, no other goroutine runs
+// in the meantime, which makes Sleep suited to precise delays in drivers.
+//
+// Interrupts wake the core early, Sleep then goes back to sleep for the
+// remaining time. Interrupt handlers which run past the deadline extend the
+// delay. Sleep uses the same alarm as the runtime scheduler so it must only be
+// called from one core at a time.
+func Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	deadline := timer.timeElapsed() + uint64(d/time.Microsecond)
+	for {
+		now := timer.timeElapsed()
+		if now >= deadline {
+			return
+		}
+		// lightSleep returns right away for delays below minSleep.
+		timer.lightSleep(deadline - now)
+	}
+}
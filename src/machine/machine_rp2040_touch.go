@@ -0,0 +1,105 @@
+//go:build rp2040
+
+package machine
+
+import (
+	"device/rp"
+	"errors"
+	"runtime/interrupt"
+)
+
+var errTouchNoCharge = errors.New("touch pin does not charge, check the pull-up resistor")
+
+// Number of charge cycles summed by TouchPin.Read, and of reads averaged for
+// the baseline.
+const (
+	touchSamples     = 8
+	touchCalibration = 16
+	// Time after which a charge cycle is given up, in microseconds of polling
+	// at 4 cycles per iteration. Real iterations take a few more cycles, so
+	// interrupts stay disabled for at most a few hundred microseconds, while a
+	// 1MΩ resistor charges a pad in a few tens.
+	touchMaxChargeMicros = 200
+)
+
+// touchMaxPolls returns the poll limit of a single charge cycle at the
+// current CPU frequency.
+func touchMaxPolls() int {
+	return int(CPUFrequency() / 1_000_000 * touchMaxChargeMicros / 4)
+}
+
+// TouchPin senses touch on a pad connected to a GPIO by measuring its
+// capacitance: the pin discharges the pad, then lets it charge through an
+// external pull-up resistor and counts how long it takes to read high. A
+// finger on the pad adds capacitance, which slows the charge down.
+//
+// A resistor of about 1MΩ between the pin and 3.3V is required, the internal
+// pull-ups are too strong to give a measurable charge time. Larger values
+// make the sensor more sensitive, and slower to read. The pad may be covered
+// by a thin insulator.
+type TouchPin struct {
+	pin      Pin
+	baseline uint32
+}
+
+// Configure sets up pin for touch sensing and calibrates the baseline used by
+// Touched, so the pad must not be touched while it runs. It returns an error if
+// the pin doesn't charge, usually because the pull-up resistor is missing.
+func (t *TouchPin) Configure(pin Pin) error {
+	if pin >= _NUMBANK0_GPIOS {
+		return ErrInvalidInputPin
+	}
+	t.pin = pin
+	pin.Configure(PinConfig{Mode: PinInput})
+	return t.Calibrate()
+}
+
+// Calibrate measures the baseline used by Touched again, to follow changes in
+// humidity or temperature. The pad must not be touched while it runs.
+func (t *TouchPin) Calibrate() error {
+	limit := uint32(touchSamples * touchMaxPolls())
+	var sum uint32
+	for i := 0; i < touchCalibration; i++ {
+		n := t.Read()
+		if n >= limit {
+			// Don't keep polling a pad which never charges.
+			return errTouchNoCharge
+		}
+		sum += n
+	}
+	t.baseline = sum / touchCalibration
+	return nil
+}
+
+// Read returns the time the pad takes to charge, in polling loop iterations of
+// a few clock cycles each, summed over several charge cycles. It grows with
+// the capacitance of the pad; the absolute value depends on the resistor, the
+// pad and the CPU frequency. Interrupts are disabled during each charge
+// cycle, a few tens of microseconds with a 1MΩ resistor. A cycle is given up
+// after a few hundred microseconds, which bounds the time spent with
+// interrupts disabled if the resistor is missing or too large.
+func (t *TouchPin) Read() uint32 {
+	mask := uint32(1) << t.pin
+	maxPolls := touchMaxPolls()
+	var sum uint32
+	for i := 0; i < touchSamples; i++ {
+		// Discharge the pad, then release it and time the charge.
+		rp.SIO.GPIO_OUT_CLR.Set(mask)
+		rp.SIO.GPIO_OE_SET.Set(mask)
+		pinPollLevel(t.pin, false, maxPolls)
+		state := interrupt.Disable()
+		rp.SIO.GPIO_OE_CLR.Set(mask)
+		sum += uint32(pinPollLevel(t.pin, true, maxPolls))
+		interrupt.Restore(state)
+	}
+	return sum
+}
+
+// Touched reports whether the pad is touched, that is whether Read returns at
+// least an eighth more than the baseline measured by Configure or Calibrate.
+// The margin suits a bare pad touched directly; thick insulators need a more
+// sensitive threshold, which can be implemented by comparing Read to a
+// baseline measured by the application.
+func (t *TouchPin) Touched() bool {
+	return t.Read() >= t.baseline+t.baseline/8
+}
@@ -15,6 +15,11 @@ type UART struct {
 }
 
 // Configure the UART.
+//
+// When Configure is called without pins, the board's UART_TX_PIN and
+// UART_RX_PIN are used, which are the UART0 pins, GPIO0 and GPIO1 on the
+// Raspberry Pi Pico. UART1 must be given its pins, UART1_TX_PIN and
+// UART1_RX_PIN on boards which define them.
 func (uart *UART) Configure(config UARTConfig) error {
 	initUART(uart)
 
@@ -85,6 +90,37 @@ func (uart *UART) SetBaudRate(br uint32) {
 	uart.Bus.UARTLCR_H.SetBits(0)
 }
 
+// Config returns the configuration currently programmed in the UART,
+// decoded from its registers: the actual baud rate, which may differ
+// slightly from the one requested, and the pins connected to the UART.
+// Pins not connected are NoPin.
+func (uart *UART) Config() UARTConfig {
+	// Inverse of SetBaudRate: the divisor is 64*IBRD + FBRD in 1/64ths.
+	div := uart.Bus.UARTIBRD.Get()<<6 + uart.Bus.UARTFBRD.Get()
+	config := UARTConfig{TX: NoPin, RX: NoPin}
+	if div != 0 {
+		config.BaudRate = uint32(uint64(4*125*MHz) / uint64(div))
+	}
+	bus := uint8(0)
+	if uart.Bus == rp.UART1 {
+		bus = 1
+	}
+	for p := Pin(0); p < _NUMBANK0_GPIOS; p++ {
+		// UART pins come in groups of 4 alternating between UART0 and
+		// UART1, starting with UART0 on GPIO0 and UART1 on GPIO4.
+		if p.getFunc() != fnUART || uint8((p+4)>>3)&1 != bus {
+			continue
+		}
+		switch p % 4 {
+		case 0:
+			config.TX = p
+		case 1:
+			config.RX = p
+		}
+	}
+	return config
+}
+
 // WriteByte writes a byte of data to the UART.
 func (uart *UART) writeByte(c byte) error {
 	// wait until buffer is not full
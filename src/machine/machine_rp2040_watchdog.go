@@ -4,6 +4,9 @@ package machine
 
 import (
 	"device/rp"
+	"errors"
+	"runtime/volatile"
+	"unsafe"
 )
 
 // Watchdog provides access to the hardware watchdog available
@@ -68,3 +71,56 @@ func (wd *watchdogImpl) Update() {
 func (wd *watchdogImpl) startTick(cycles uint32) {
 	rp.WATCHDOG.TICK.Set(cycles | rp.WATCHDOG_TICK_ENABLE)
 }
+
+// Reset performs a reset of the whole chip by triggering the watchdog
+// immediately. It does not return.
+//
+// There is no way to pull the RUN pin low from software, so this is the
+// closest equivalent: the power-on state machine resets every block except the
+// ring and crystal oscillators, and the chip boots from scratch. This differs
+// from CPUReset, which only requests a reset of the processors through the
+// SYSRESETREQ bit and leaves peripherals in their current state. Use Reset
+// when a known-clean state is required, e.g. after a firmware update.
+//
+// See EnterBootloader to reset into the USB bootloader instead.
+func Reset() {
+	// Clear the bootrom's watchdog boot vector magic so that the bootrom
+	// performs a normal boot instead of jumping to a stale entry point.
+	rp.WATCHDOG.SCRATCH4.Set(0)
+
+	// Reset everything apart from ROSC and XOSC.
+	rp.PSM.WDSEL.Set(0x0001ffff &^ (rp.PSM_WDSEL_ROSC | rp.PSM_WDSEL_XOSC))
+
+	rp.WATCHDOG.CTRL.SetBits(rp.WATCHDOG_CTRL_TRIGGER)
+	for {
+	}
+}
+
+var errScratchIndex = errors.New("watchdog scratch register index must be in 0..7")
+
+var watchdogScratch = (*[8]volatile.Register32)(unsafe.Pointer(&rp.WATCHDOG.SCRATCH0))
+
+// SetScratch stores value in the watchdog scratch register index, 0 to 7.
+// Scratch registers keep their value across watchdog resets, including Reset,
+// and soft resets, which lets firmware pass a little state to the next boot,
+// such as a request to start in update mode. They are cleared at power-on.
+//
+// Registers 4 to 7 are used by the bootrom to find the entry point after a
+// watchdog reset, and register 4 is cleared by Reset, so applications should
+// only use registers 0 to 3.
+func SetScratch(index uint8, value uint32) error {
+	if index >= uint8(len(watchdogScratch)) {
+		return errScratchIndex
+	}
+	watchdogScratch[index].Set(value)
+	return nil
+}
+
+// GetScratch returns the value of the watchdog scratch register index, see
+// SetScratch. It returns 0 if index is out of range.
+func GetScratch(index uint8) uint32 {
+	if index >= uint8(len(watchdogScratch)) {
+		return 0
+	}
+	return watchdogScratch[index].Get()
+}
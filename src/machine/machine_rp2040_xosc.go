@@ -40,3 +40,16 @@ func (osc *xoscType) init() {
 	for !osc.status.HasBits(rp.XOSC_STATUS_STABLE) {
 	}
 }
+
+// goDormant puts the crystal oscillator, and with it every clock derived from
+// it, into dormant mode. The chip stays stopped until a dormant wake event
+// occurs. goDormant returns once the oscillator is stable again.
+func (osc *xoscType) goDormant() {
+	// Magic value ("coma" in ASCII) that must be written to enter dormant mode.
+	const xoscDormantValue = 0x636f6d61
+	osc.dormant.Set(xoscDormantValue)
+
+	// Wait for xosc to be stable
+	for !osc.status.HasBits(rp.XOSC_STATUS_STABLE) {
+	}
+}